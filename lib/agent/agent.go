@@ -51,6 +51,7 @@ type Context struct {
 	sessions   *Sessions
 	events     *Events
 	projects   *Projects
+	tokens     *Tokens
 
 	Exit chan os.Signal
 }
@@ -183,6 +184,9 @@ func (ctx *Context) Run() (int, error) {
 		ctx.Log.Infof("Cloud Sync / Syncthing not supported")
 	}
 
+	// Load issued access tokens
+	ctx.tokens = NewTokens(ctx)
+
 	// Create Web Server
 	ctx.webServer = NewWebServer(ctx)
 
@@ -220,6 +224,12 @@ func handlerSigTerm(ctx *Context) {
 		ctx.SThg.Stop()
 		ctx.SThg.StopInotify()
 	}
+	if ctx.sessions != nil {
+		ctx.Log.Infof("Saving sessions...")
+		if err := ctx.sessions.Save(); err != nil {
+			ctx.Log.Errorf("Cannot save sessions: %v", err)
+		}
+	}
 	if ctx.webServer != nil {
 		ctx.Log.Infof("Stoping Web server...")
 		ctx.webServer.Stop()
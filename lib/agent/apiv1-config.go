@@ -50,10 +50,14 @@ func (s *APIService) setConfig(c *gin.Context) {
 	confMut.Lock()
 	defer confMut.Unlock()
 
+	// Save changes even if a server cannot be connected
+	defer s.saveServersConf()
+
 	s.Log.Debugln("SET config: ", cfgArg)
 
-	// First delete/disable XDS Server that are no longer listed
-	for _, svr := range s.xdsServers {
+	// First delete XDS Server that are no longer listed (connected or not)
+	svrList := append([]*XdsServer{}, s.svrList...)
+	for _, svr := range svrList {
 		found := false
 		for _, svrArg := range cfgArg.Servers {
 			if svr.ID == svrArg.ID {
@@ -69,12 +73,18 @@ func (s *APIService) setConfig(c *gin.Context) {
 	// Add new XDS Server
 	for _, svr := range cfgArg.Servers {
 		if svr.Connected && svr.ID != "" {
+			// Only labels can be changed on a connected server
+			if sv, exist := s.findListedServer(svr.ID); exist && svr.Labels != nil {
+				sv.Labels = svr.Labels
+			}
 			continue
 		}
 		cfg := xdsconfig.XDSServerConf{
 			ID:        svr.ID,
 			URL:       svr.URL,
 			ConnRetry: svr.ConnRetry,
+			Name:      svr.Name,
+			Labels:    svr.Labels,
 		}
 		if _, err := s.AddXdsServer(cfg); err != nil {
 			common.APIError(c, err.Error())
@@ -93,16 +103,8 @@ func (s *APIService) _getConfig() xaapiv1.APIConfig {
 		Servers:       []xaapiv1.ServerCfg{},
 	}
 
-	for _, svr := range s.xdsServers {
-		cfg.Servers = append(cfg.Servers, xaapiv1.ServerCfg{
-			ID:         svr.ID,
-			URL:        svr.BaseURL,
-			APIURL:     svr.APIURL,
-			PartialURL: svr.PartialURL,
-			ConnRetry:  svr.ConnRetry,
-			Connected:  svr.Connected,
-			Disabled:   svr.Disabled,
-		})
+	for _, svr := range s.svrList {
+		cfg.Servers = append(cfg.Servers, svr._GetServerCfg())
 	}
 	return cfg
 }
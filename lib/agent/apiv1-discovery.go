@@ -0,0 +1,145 @@
+/*
+ * Copyright (C) 2017 "IoT.bzh"
+ * Author Sebastien Douheret <sebastien@iot.bzh>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package agent
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/iotbzh/xds-agent/lib/discovery"
+	"github.com/iotbzh/xds-agent/lib/xaapiv1"
+	"github.com/iotbzh/xds-agent/lib/xdsconfig"
+	common "github.com/iotbzh/xds-common/golib"
+)
+
+const defaultDiscoveryTimeout = 1000 // in ms
+
+// getDiscoveredServers probes local network and returns found XDS Servers
+func (s *APIService) getDiscoveredServers(c *gin.Context) {
+	list, err := s._discoverServers()
+	if err != nil {
+		common.APIError(c, err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, list)
+}
+
+// adoptServer adds a discovered XDS Server to the list of used servers
+func (s *APIService) adoptServer(c *gin.Context) {
+	var args xaapiv1.ServerAdoptArgs
+	if c.BindJSON(&args) != nil || (args.URL == "" && args.Name == "") {
+		common.APIError(c, "Invalid arguments")
+		return
+	}
+
+	s.discoMutex.Lock()
+	var found *xaapiv1.DiscoveredServer
+	for _, d := range s.discovered {
+		if (args.URL != "" && d.URL == args.URL) || (args.URL == "" && d.Name == args.Name) {
+			dd := d
+			found = &dd
+			break
+		}
+	}
+	s.discoMutex.Unlock()
+
+	if found == nil {
+		common.APIError(c, "Unknown discovered server (use GET /servers/discovered first)")
+		return
+	}
+	if s._isServerUsed(found.URL) {
+		common.APIError(c, "Server already used")
+		return
+	}
+
+	confMut.Lock()
+	defer confMut.Unlock()
+	defer s.saveServersConf()
+
+	s.Log.Infof("Adopt discovered server %s (url %s)", found.Name, found.URL)
+
+	cfg := xdsconfig.XDSServerConf{
+		URL:       found.URL,
+		ConnRetry: 1,
+		Name:      found.Name,
+	}
+	if cfg.Name != "" {
+		if err := s.checkServerName(cfg.Name); err != nil {
+			s.Log.Warningf("Announced name of server %s not used: %v", found.URL, err)
+			cfg.Name = ""
+		}
+	}
+	if _, err := s.AddXdsServer(cfg); err != nil {
+		common.APIError(c, err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, s._getConfig())
+}
+
+// _discoverServers Broadcast a probe and update list of discovered servers
+func (s *APIService) _discoverServers() ([]xaapiv1.DiscoveredServer, error) {
+	dCfg := s.Config.FileConf.Discovery
+	if dCfg.Disable {
+		return []xaapiv1.DiscoveredServer{}, nil
+	}
+	timeout := dCfg.Timeout
+	if timeout <= 0 {
+		timeout = defaultDiscoveryTimeout
+	}
+
+	replies, err := discovery.Probe(dCfg.Port, time.Duration(timeout)*time.Millisecond)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().Format(time.RFC3339)
+	list := []xaapiv1.DiscoveredServer{}
+	for _, r := range replies {
+		list = append(list, xaapiv1.DiscoveredServer{
+			Name:             r.Name,
+			URL:              r.URL,
+			Version:          r.Version,
+			APIVersion:       r.APIVersion,
+			SupportedSharing: r.SupportedSharing,
+			Address:          r.From,
+			LastSeen:         now,
+			Adopted:          s._isServerUsed(r.URL),
+		})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].URL < list[j].URL })
+
+	s.discoMutex.Lock()
+	s.discovered = list
+	s.discoMutex.Unlock()
+
+	return list, nil
+}
+
+// _isServerUsed returns true when a server with the same URL is already used
+func (s *APIService) _isServerUsed(url string) bool {
+	for _, svr := range s.xdsServers {
+		if !svr.Disabled && strings.TrimRight(svr.BaseURL, "/") == strings.TrimRight(url, "/") {
+			return true
+		}
+	}
+	return false
+}
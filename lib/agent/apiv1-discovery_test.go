@@ -0,0 +1,63 @@
+/*
+ * Copyright (C) 2017 "IoT.bzh"
+ * Author Sebastien Douheret <sebastien@iot.bzh>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package agent
+
+import (
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/iotbzh/xds-agent/lib/discovery"
+	"github.com/iotbzh/xds-agent/lib/xdstest"
+)
+
+func TestAdoptDiscoveredServer(t *testing.T) {
+	ctx, cleanup := newTestContext(t)
+	defer cleanup()
+	api := NewAPIV1(ctx)
+	defer api.Stop()
+
+	fake := xdstest.NewServer()
+	defer fake.Close()
+
+	r, err := discovery.NewResponder("127.0.0.1:0", discovery.Announce{Name: "lab", URL: fake.URL, APIVersion: "1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	go r.Serve()
+	ctx.Config.FileConf.Discovery.Port = r.Addr().(*net.UDPAddr).Port
+	ctx.Config.FileConf.Discovery.Timeout = 300
+
+	code, body := serveAPIJSON(ctx, "GET", "/servers/discovered", "")
+	if code != http.StatusOK || !strings.Contains(body, fake.URL) {
+		t.Fatalf("GET /servers/discovered: status %d, body %s", code, body)
+	}
+
+	code, body = serveAPIJSON(ctx, "POST", "/servers/discovered/adopt", `{"url":"`+fake.URL+`"}`)
+	if code != http.StatusOK {
+		t.Fatalf("POST /servers/discovered/adopt: status %d, body %s", code, body)
+	}
+	svr, exist := ctx.getXdsServer("lab")
+	if !exist {
+		t.Fatalf("adopted server not found by its announced name")
+	}
+	if svr.BaseURL != fake.URL {
+		t.Errorf("adopted server URL %s, want %s", svr.BaseURL, fake.URL)
+	}
+}
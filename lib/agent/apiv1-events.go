@@ -50,6 +50,7 @@ func (s *APIService) eventsRegister(c *gin.Context) {
 		common.APIError(c, err.Error())
 		return
 	}
+	s.sessions.MarkDirty()
 
 	c.JSON(http.StatusOK, gin.H{"status": "OK"})
 }
@@ -74,6 +75,7 @@ func (s *APIService) eventsUnRegister(c *gin.Context) {
 		common.APIError(c, err.Error())
 		return
 	}
+	s.sessions.MarkDirty()
 
 	c.JSON(http.StatusOK, gin.H{"status": "OK"})
 }
@@ -73,6 +73,7 @@ func (s *APIService) execCmd(c *gin.Context) {
 	}
 
 	// Forward input events from client to XDSServer through WS
+	// (rate of inbound events is limited by session socket)
 	// TODO use XDSServer events names definition
 	evtInList := []string{
 		xaapiv1.ExecInEvent,
@@ -154,6 +155,7 @@ func (s *APIService) execCmd(c *gin.Context) {
 			svr := (*prj).GetServer()
 			if svr != nil && cmdIDExist {
 				svr.CommandDelete(cmdIDData.(string))
+				s.sessions.CommandDelete(sid, cmdIDData.(string))
 			} else {
 				s.Log.Infof("%s: cannot retrieve server for sid=%s, prjID=%s, evD=%v", evN, sid, prjID, evD)
 			}
@@ -203,6 +205,7 @@ func (s *APIService) execCmd(c *gin.Context) {
 		common.APIError(c, err.Error())
 		return
 	}
+	s.sessions.CommandAdd(sess.ID, res.CmdID)
 
 	c.JSON(http.StatusOK, xaapiv1.ExecResult{Status: res.Status, CmdID: res.CmdID})
 }
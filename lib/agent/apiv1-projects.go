@@ -37,18 +37,19 @@ func (s *APIService) getProject(c *gin.Context) {
 		common.APIError(c, err.Error())
 		return
 	}
-	prj := s.projects.Get(id)
-	if prj == nil {
+	prj, exist := s.projects.GetConfig(id)
+	if !exist {
 		common.APIError(c, "Invalid id")
 		return
 	}
 
-	c.JSON(http.StatusOK, (*prj).GetProject())
+	c.JSON(http.StatusOK, prj)
 }
 
 // addProject adds a new project to server config
+// (project files are first created when a template is set)
 func (s *APIService) addProject(c *gin.Context) {
-	var cfgArg xaapiv1.ProjectConfig
+	var cfgArg xaapiv1.ProjectAddArgs
 	if c.BindJSON(&cfgArg) != nil {
 		common.APIError(c, "Invalid arguments")
 		return
@@ -56,7 +57,7 @@ func (s *APIService) addProject(c *gin.Context) {
 
 	s.Log.Debugln("Add project config: ", cfgArg)
 
-	newFld, err := s.projects.Add(cfgArg, s.sessions.GetID(c), c.Request.Host)
+	newFld, err := s.projects.AddFromTemplate(cfgArg, s.sessions.GetID(c))
 	if err != nil {
 		common.APIError(c, err.Error())
 		return
@@ -65,14 +66,95 @@ func (s *APIService) addProject(c *gin.Context) {
 	c.JSON(http.StatusOK, newFld)
 }
 
-// syncProject force synchronization of project files
-func (s *APIService) syncProject(c *gin.Context) {
+// projectCommand dispatches POST /projects/<command>
+func (s *APIService) projectCommand(c *gin.Context) {
+	switch c.Param("id") {
+	case "import":
+		s.importProject(c)
+	case "discover":
+		s.discoverProjects(c)
+	default:
+		common.APIError(c, "Unknown command")
+	}
+}
+
+// exportProject returns portable definition of a project
+func (s *APIService) exportProject(c *gin.Context) {
 	id, err := s.projects.ResolveID(c.Param("id"))
 	if err != nil {
 		common.APIError(c, err.Error())
 		return
 	}
 
+	bundle, err := s.projects.Export(id, c.Query("clientRoot"), c.Query("serverRoot"))
+	if err != nil {
+		common.APIError(c, err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, bundle)
+}
+
+// importProject creates a new project from a bundle
+func (s *APIService) importProject(c *gin.Context) {
+	var args xaapiv1.ProjectImportArgs
+	if c.BindJSON(&args) != nil {
+		common.APIError(c, "Invalid arguments")
+		return
+	}
+
+	s.Log.Debugln("Import project: ", args)
+
+	newPrj, err := s.projects.Import(args, s.sessions.GetID(c))
+	if err != nil {
+		common.APIError(c, err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, newPrj)
+}
+
+// discoverProjects scans a directory for xds-project.conf files and
+// registers or re-links the requested ones
+func (s *APIService) discoverProjects(c *gin.Context) {
+	var args xaapiv1.ProjectDiscoverArgs
+	if c.BindJSON(&args) != nil {
+		common.APIError(c, "Invalid arguments")
+		return
+	}
+
+	s.Log.Debugln("Discover projects: ", args)
+
+	res, err := s.projects.Discover(args, s.sessions.GetID(c))
+	if err != nil {
+		common.APIError(c, err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, res)
+}
+
+// projectAction dispatches POST /projects/sync/:id and /projects/:id/:action
+func (s *APIService) projectAction(c *gin.Context) {
+	if c.Param("id") == "sync" {
+		s.syncProject(c, c.Param("action"))
+		return
+	}
+	switch c.Param("action") {
+	case "migrate":
+		s.migrateProject(c, c.Param("id"))
+	case "files":
+		s.setProjectFiles(c, c.Param("id"))
+	default:
+		common.APIError(c, "Unknown action")
+	}
+}
+
+// syncProject force synchronization of project files
+func (s *APIService) syncProject(c *gin.Context, idArg string) {
+	id, err := s.projects.ResolveID(idArg)
+	if err != nil {
+		common.APIError(c, err.Error())
+		return
+	}
+
 	s.Log.Debugln("Sync project id: ", id)
 
 	err = s.projects.ForceSync(id)
@@ -125,3 +207,54 @@ func (s *APIService) updateProject(c *gin.Context) {
 	}
 	c.JSON(http.StatusOK, upPrj)
 }
+
+// migrateProject moves a project to another XDS Server
+func (s *APIService) migrateProject(c *gin.Context, idArg string) {
+	id, err := s.projects.ResolveID(idArg)
+	if err != nil {
+		common.APIError(c, err.Error())
+		return
+	}
+
+	var args xaapiv1.ProjectMigrateArgs
+	if c.BindJSON(&args) != nil {
+		common.APIError(c, "Invalid arguments")
+		return
+	}
+
+	s.Log.Debugln("Migrate project id ", id, " to server ", args.ServerID)
+
+	prj, err := s.projects.Migrate(id, args, s.sessions.GetID(c))
+	if err != nil {
+		common.APIError(c, err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, prj)
+}
+
+// getTemplates returns all templates that can be used to create a project
+func (s *APIService) getTemplates(c *gin.Context) {
+	c.JSON(http.StatusOK, s.projects.GetTemplates())
+}
+
+// setProjectFiles changes settings files maintained in project directory
+func (s *APIService) setProjectFiles(c *gin.Context, idArg string) {
+	id, err := s.projects.ResolveID(idArg)
+	if err != nil {
+		common.APIError(c, err.Error())
+		return
+	}
+
+	var args xaapiv1.ProjectFilesArgs
+	if c.BindJSON(&args) != nil {
+		common.APIError(c, "Invalid arguments")
+		return
+	}
+
+	prj, err := s.projects.SetFiles(id, args.Files, args.AgentURL)
+	if err != nil {
+		common.APIError(c, err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, prj)
+}
@@ -0,0 +1,95 @@
+/*
+ * Copyright (C) 2017 "IoT.bzh"
+ * Author Sebastien Douheret <sebastien@iot.bzh>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package agent
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/iotbzh/xds-agent/lib/xaapiv1"
+	"github.com/iotbzh/xds-agent/lib/xdsconfig"
+	"github.com/iotbzh/xds-agent/lib/xdstest"
+)
+
+// TestProjectLifecycle creates and deletes a path-mapping project through
+// agent REST API and a fake XDS Server
+func TestProjectLifecycle(t *testing.T) {
+	ctx, cleanup := newTestContext(t)
+	defer cleanup()
+	api := NewAPIV1(ctx)
+	defer api.Stop()
+
+	fake := xdstest.NewServer()
+	defer fake.Close()
+
+	svr, err := api.AddXdsServer(xdsconfig.XDSServerConf{URL: fake.URL, ConnRetry: 1, HealthPeriod: -1})
+	if err != nil {
+		t.Fatalf("cannot add server: %v", err)
+	}
+	for end := time.Now().Add(5 * time.Second); !svr.Connected && time.Now().Before(end); {
+		time.Sleep(50 * time.Millisecond)
+	}
+	if !svr.Connected {
+		t.Fatalf("server not connected")
+	}
+
+	dir, err := ioutil.TempDir("", "xds-agent-prj")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	args, _ := json.Marshal(xaapiv1.ProjectConfig{
+		ServerID:   svr.ID,
+		Label:      "e2e",
+		ClientPath: dir,
+		Type:       xaapiv1.TypePathMap,
+	})
+	code, body := serveAPIJSON(ctx, "POST", "/projects", string(args))
+	if code != http.StatusOK {
+		t.Fatalf("POST /projects: status %d, body %s", code, body)
+	}
+	prj := xaapiv1.ProjectConfig{}
+	if err := json.Unmarshal([]byte(body), &prj); err != nil {
+		t.Fatal(err)
+	}
+	flds := fake.Folders()
+	if len(flds) != 1 || flds[0].ID != prj.ID || flds[0].ClientPath != dir {
+		t.Fatalf("project not created on server: %+v", flds)
+	}
+	data, err := ioutil.ReadFile(filepath.Join(dir, projectConfFile))
+	if err != nil || !strings.Contains(string(data), "export XDS_PROJECT_ID="+prj.ID) {
+		t.Errorf("%s not generated: %v\n%s", projectConfFile, err, data)
+	}
+
+	if code, body = serveAPIJSON(ctx, "GET", "/projects", ""); code != http.StatusOK || !strings.Contains(body, prj.ID) {
+		t.Errorf("GET /projects: status %d, body %s", code, body)
+	}
+
+	if code, body = serveAPIJSON(ctx, "DELETE", "/projects/"+prj.ID, ""); code != http.StatusOK {
+		t.Fatalf("DELETE /projects/%s: status %d, body %s", prj.ID, code, body)
+	}
+	if flds = fake.Folders(); len(flds) != 0 {
+		t.Errorf("project not deleted on server: %+v", flds)
+	}
+}
@@ -25,17 +25,6 @@ import (
 	"github.com/iotbzh/xds-server/lib/xsapiv1"
 )
 
-// sdksPassthroughInit Declare passthrough routes for sdks
-func (s *APIService) sdksPassthroughInit(svr *XdsServer) error {
-	svr.PassthroughGet("/sdks")
-	svr.PassthroughGet("/sdks/:id")
-	svr.PassthroughPost("/sdks")
-	svr.PassthroughPost("/sdks/abortinstall")
-	svr.PassthroughDelete("/sdks/:id")
-
-	return nil
-}
-
 // sdksEventsForwardInit Register events forwarder for sdks
 func (s *APIService) sdksEventsForwardInit(svr *XdsServer) error {
 
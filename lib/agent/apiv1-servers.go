@@ -0,0 +1,48 @@
+/*
+ * Copyright (C) 2017 "IoT.bzh"
+ * Author Sebastien Douheret <sebastien@iot.bzh>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package agent
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// serversRoutesInit Declare routes of a XDS Server that are handled by agent
+// (eg. /servers/0/health or /servers/0/reconnect)
+func (s *APIService) serversRoutesInit(svr *XdsServer) error {
+	svr.LocalRoute("GET", "/health", func(c *gin.Context) {
+		s.getServerHealth(c, svr)
+	})
+	svr.LocalRoute("POST", "/reconnect", func(c *gin.Context) {
+		s.reconnectServer(c, svr)
+	})
+
+	return nil
+}
+
+// getServerHealth returns health and probes history of a XDS Server
+func (s *APIService) getServerHealth(c *gin.Context, svr *XdsServer) {
+	c.JSON(http.StatusOK, svr.GetHealth(true))
+}
+
+// reconnectServer triggers an immediate reconnection attempt to a XDS Server
+func (s *APIService) reconnectServer(c *gin.Context, svr *XdsServer) {
+	svr.Reconnect()
+	c.JSON(http.StatusOK, svr.GetReconnectState())
+}
@@ -0,0 +1,138 @@
+/*
+ * Copyright (C) 2017 "IoT.bzh"
+ * Author Sebastien Douheret <sebastien@iot.bzh>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package agent
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/iotbzh/xds-agent/lib/xaapiv1"
+	"github.com/iotbzh/xds-agent/lib/xdsconfig"
+	"github.com/iotbzh/xds-agent/lib/xdstest"
+)
+
+func TestServerAddDeleteAdd(t *testing.T) {
+	ctx, cleanup := newTestContext(t)
+	defer cleanup()
+	api := NewAPIV1(ctx)
+	defer api.Stop()
+
+	fake := xdstest.NewServer()
+	defer fake.Close()
+
+	cfg := xdsconfig.XDSServerConf{URL: fake.URL, ConnRetry: 1, Name: "build", HealthPeriod: -1}
+	svr, err := api.AddXdsServer(cfg)
+	if err != nil {
+		t.Fatalf("cannot add server: %v", err)
+	}
+	if code := serveAPI(ctx, "GET", "/servers/build/health"); code != http.StatusOK {
+		t.Fatalf("GET /servers/build/health: status %d, want %d", code, http.StatusOK)
+	}
+	idxURL := svr.PartialURL
+
+	if err := api.DelXdsServer(svr.ID); err != nil {
+		t.Fatalf("cannot delete server: %v", err)
+	}
+	if code := serveAPI(ctx, "GET", "/servers/build/health"); code != http.StatusNotFound {
+		t.Errorf("GET /servers/build/health of deleted server: status %d, want %d", code, http.StatusNotFound)
+	}
+	if code := serveAPI(ctx, "POST", idxURL+"/reconnect"); code != http.StatusNotFound {
+		t.Errorf("POST %s/reconnect of deleted server: status %d, want %d", idxURL, code, http.StatusNotFound)
+	}
+	if svr.Connected || !svr.Disabled {
+		t.Errorf("deleted server must stay disconnected")
+	}
+
+	// Same name must be usable again (route already declared in router)
+	svr2, err := api.AddXdsServer(cfg)
+	if err != nil {
+		t.Fatalf("cannot add server again: %v", err)
+	}
+	if svr2 == svr {
+		t.Fatalf("a new server object must be created")
+	}
+	if code := serveAPI(ctx, "GET", "/servers/build/health"); code != http.StatusOK {
+		t.Errorf("GET /servers/build/health after re-add: status %d, want %d", code, http.StatusOK)
+	}
+	if _, exist := ctx.getXdsServer("build"); !exist {
+		t.Errorf("server not found by name after re-add")
+	}
+}
+
+func TestServerNameReserved(t *testing.T) {
+	ctx, cleanup := newTestContext(t)
+	defer cleanup()
+	api := NewAPIV1(ctx)
+
+	for _, name := range []string{"discovered", "auto", "tempo-123", "0abc", ""} {
+		if err := api.checkServerName(name); err == nil {
+			t.Errorf("name '%s' must be rejected", name)
+		}
+	}
+	if err := api.checkServerName("lab1.build-farm"); err != nil {
+		t.Errorf("valid name rejected: %v", err)
+	}
+}
+
+func TestConfigUnreachableServer(t *testing.T) {
+	ctx, cleanup := newTestContext(t)
+	defer cleanup()
+	api := NewAPIV1(ctx)
+	defer api.Stop()
+
+	badURL := "http://127.0.0.1:1"
+	body := `{"servers": [{"url": "` + badURL + `", "connRetry": 1}]}`
+	if code, _ := serveAPIJSON(ctx, "POST", "/config", body); code == http.StatusOK {
+		t.Fatalf("POST /config with an unreachable server must fail")
+	}
+
+	// Server is visible (so it can be deleted) but not saved
+	code, resp := serveAPIJSON(ctx, "GET", "/config", "")
+	cfg := xaapiv1.APIConfig{}
+	if code != http.StatusOK || json.Unmarshal([]byte(resp), &cfg) != nil {
+		t.Fatalf("GET /config: status %d, body %s", code, resp)
+	}
+	if len(cfg.Servers) != 1 || cfg.Servers[0].URL != badURL || cfg.Servers[0].Connected {
+		t.Fatalf("unreachable server not listed: %+v", cfg.Servers)
+	}
+	svr := api.svrList[0]
+	if data, err := ioutil.ReadFile(ctx.Config.FileConf.StateFile); err == nil && strings.Contains(string(data), badURL) {
+		t.Errorf("unreachable server must not be saved: %s", data)
+	}
+
+	// Delete it
+	if code, resp := serveAPIJSON(ctx, "POST", "/config", `{"servers": []}`); code != http.StatusOK {
+		t.Fatalf("POST /config: status %d, body %s", code, resp)
+	}
+	code, resp = serveAPIJSON(ctx, "GET", "/config", "")
+	cfg = xaapiv1.APIConfig{}
+	if code != http.StatusOK || json.Unmarshal([]byte(resp), &cfg) != nil || len(cfg.Servers) != 0 {
+		t.Fatalf("server not deleted: status %d, body %s", code, resp)
+	}
+
+	// Background reconnection must be stopped
+	for i := 0; svr.GetReconnectState().Active; i++ {
+		if i == 20 {
+			t.Fatalf("reconnection of deleted server still running")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
@@ -0,0 +1,64 @@
+/*
+ * Copyright (C) 2017 "IoT.bzh"
+ * Author Sebastien Douheret <sebastien@iot.bzh>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package agent
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/iotbzh/xds-agent/lib/xaapiv1"
+	common "github.com/iotbzh/xds-common/golib"
+)
+
+// getTokens returns the list of issued access tokens
+func (s *APIService) getTokens(c *gin.Context) {
+	c.JSON(http.StatusOK, s.tokens.List())
+}
+
+// addToken issues a new access token
+func (s *APIService) addToken(c *gin.Context) {
+	var args xaapiv1.TokenCreateArgs
+	if c.BindJSON(&args) != nil {
+		common.APIError(c, "Invalid arguments")
+		return
+	}
+
+	s.Log.Debugln("Add token: ", args.Name, args.Scopes)
+
+	tok, err := s.tokens.Create(args)
+	if err != nil {
+		common.APIError(c, err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, tok)
+}
+
+// delToken revokes an access token
+func (s *APIService) delToken(c *gin.Context) {
+	id := c.Param("id")
+
+	s.Log.Debugln("Delete token id ", id)
+
+	delEntry, err := s.tokens.Revoke(id)
+	if err != nil {
+		common.APIError(c, err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, delEntry)
+}
@@ -20,11 +20,15 @@ package agent
 import (
 	"fmt"
 	"net/http"
+	"sync"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/iotbzh/xds-agent/lib/xaapiv1"
 )
 
+const versionRequestTimeout = 5 * time.Second
+
 // getInfo : return various information about server
 func (s *APIService) getVersion(c *gin.Context) {
 	response := xaapiv1.XDSVersion{
@@ -36,18 +40,40 @@ func (s *APIService) getVersion(c *gin.Context) {
 		},
 	}
 
-	svrVer := []xaapiv1.VersionData{}
+	// Query all servers in parallel
+	svrList := []*XdsServer{}
 	for _, svr := range s.xdsServers {
-		res := xaapiv1.VersionData{}
-		if err := svr.GetVersion(&res); err != nil {
-			errMsg := fmt.Sprintf("Cannot retrieve version of XDS server ID %s : %v", svr.ID, err.Error())
-			s.Log.Warning(errMsg)
-			res.ID = svr.ID
-			res.Version = errMsg
-		}
-		svrVer = append(svrVer, res)
+		svrList = append(svrList, svr)
+	}
+	svrVer := make([]xaapiv1.VersionData, len(svrList))
+	wg := sync.WaitGroup{}
+	for i, svr := range svrList {
+		wg.Add(1)
+		go func(i int, svr *XdsServer) {
+			defer wg.Done()
+			svrVer[i] = s._getServerVersion(svr)
+		}(i, svr)
 	}
+	wg.Wait()
+
 	response.Server = svrVer
 
 	c.JSON(http.StatusOK, response)
 }
+
+// _getServerVersion Retrieve version of a server (or an error message when
+// server doesn't reply before timeout)
+func (s *APIService) _getServerVersion(svr *XdsServer) xaapiv1.VersionData {
+	res := xaapiv1.VersionData{}
+	err := svr.GetVersion(&res, versionRequestTimeout)
+	if err == nil {
+		return res
+	}
+
+	errMsg := fmt.Sprintf("Cannot retrieve version of XDS server ID %s : %v", svr.ID, err.Error())
+	s.Log.Warning(errMsg)
+	return xaapiv1.VersionData{
+		ID:      svr.ID,
+		Version: errMsg,
+	}
+}
@@ -22,8 +22,10 @@ import (
 	"strconv"
 
 	"github.com/gin-gonic/gin"
+	"github.com/iotbzh/xds-agent/lib/xaapiv1"
 	"github.com/iotbzh/xds-agent/lib/xdsconfig"
 	"github.com/iotbzh/xds-server/lib/xsapiv1"
+	"github.com/syncthing/syncthing/lib/sync"
 )
 
 const apiBaseURL = "/api/v1"
@@ -33,6 +35,11 @@ type APIService struct {
 	*Context
 	apiRouter   *gin.RouterGroup
 	serverIndex int
+	svrList     []*XdsServer    // all servers (connected or not) in creation order
+	svrRoutes   map[string]bool // declared /servers/<index|name> routes
+	discovered  []xaapiv1.DiscoveredServer
+	discoMutex  sync.Mutex
+	saveMutex   sync.Mutex
 }
 
 // NewAPIV1 creates a new instance of API service
@@ -41,6 +48,10 @@ func NewAPIV1(ctx *Context) *APIService {
 		Context:     ctx,
 		apiRouter:   ctx.webServer.router.Group(apiBaseURL),
 		serverIndex: 0,
+		svrRoutes:   make(map[string]bool),
+		discovered:  []xaapiv1.DiscoveredServer{},
+		discoMutex:  sync.NewMutex(),
+		saveMutex:   sync.NewMutex(),
 	}
 
 	s.apiRouter.GET("/version", s.getVersion)
@@ -50,13 +61,23 @@ func NewAPIV1(ctx *Context) *APIService {
 
 	s.apiRouter.GET("/browse", s.browseFS)
 
+	s.apiRouter.GET("/servers/discovered", s.getDiscoveredServers)
+	s.apiRouter.POST("/servers/discovered/adopt", s.adoptServer)
+
 	s.apiRouter.GET("/projects", s.getProjects)
 	s.apiRouter.GET("/projects/:id", s.getProject)
+	s.apiRouter.GET("/projects/:id/export", s.exportProject)
 	s.apiRouter.PUT("/projects/:id", s.updateProject)
 	s.apiRouter.POST("/projects", s.addProject)
-	s.apiRouter.POST("/projects/sync/:id", s.syncProject)
+	// Note: POST /projects/sync/:id and /projects/:id/migrate share the same
+	// route to avoid wildcard conflict in router (same for /projects/import
+	// and /projects/discover)
+	s.apiRouter.POST("/projects/:id", s.projectCommand)
+	s.apiRouter.POST("/projects/:id/:action", s.projectAction)
 	s.apiRouter.DELETE("/projects/:id", s.delProject)
 
+	s.apiRouter.GET("/templates", s.getTemplates)
+
 	s.apiRouter.POST("/exec", s.execCmd)
 	s.apiRouter.POST("/exec/:id", s.execCmd)
 	s.apiRouter.POST("/signal", s.execSignalCmd)
@@ -65,12 +86,19 @@ func NewAPIV1(ctx *Context) *APIService {
 	s.apiRouter.POST("/events/register", s.eventsRegister)
 	s.apiRouter.POST("/events/unregister", s.eventsUnRegister)
 
+	s.apiRouter.GET("/sessions", s.getSessions)
+	s.apiRouter.DELETE("/sessions/:id", s.delSession)
+
+	s.apiRouter.GET("/tokens", s.getTokens)
+	s.apiRouter.POST("/tokens", s.addToken)
+	s.apiRouter.DELETE("/tokens/:id", s.delToken)
+
 	return s
 }
 
 // Stop Used to stop/close created services
 func (s *APIService) Stop() {
-	for _, svr := range s.xdsServers {
+	for _, svr := range s.svrList {
 		svr.Close()
 	}
 }
@@ -81,11 +109,18 @@ func (s *APIService) AddXdsServer(cfg xdsconfig.XDSServerConf) (*XdsServer, erro
 	var exist, tempoID bool
 	tempoID = false
 
-	// First check if not already exist and update it
-	if svr, exist = s.xdsServers[cfg.ID]; exist {
+	// First check if not already exist and update it (server may not be
+	// registered yet when it has never been connected)
+	if svr, exist = s.findListedServer(cfg.ID); exist {
 
 		// Update: Found, so just update some settings
+		if cfg.Name != "" && cfg.Name != svr.Name {
+			return svr, fmt.Errorf("Server %s cannot be renamed", svr.ID)
+		}
 		svr.ConnRetry = cfg.ConnRetry
+		if cfg.Labels != nil {
+			svr.Labels = cfg.Labels
+		}
 
 		tempoID = svr.IsTempoID()
 		if svr.Connected && !svr.Disabled && svr.BaseURL == cfg.URL && tempoID {
@@ -98,6 +133,11 @@ func (s *APIService) AddXdsServer(cfg xdsconfig.XDSServerConf) (*XdsServer, erro
 	} else {
 
 		// Create a new server object
+		if cfg.Name != "" {
+			if err := s.checkServerName(cfg.Name); err != nil {
+				return nil, err
+			}
+		}
 		if cfg.APIBaseURL == "" {
 			cfg.APIBaseURL = apiBaseURL
 		}
@@ -108,22 +148,52 @@ func (s *APIService) AddXdsServer(cfg xdsconfig.XDSServerConf) (*XdsServer, erro
 
 		// Create a new XDS Server
 		svr = NewXdsServer(s.Context, cfg)
+		s.svrList = append(s.svrList, svr)
 
 		svr.SetLoggerOutput(s.Config.LogVerboseOut)
 
 		// Passthrough routes (handle by XDS Server)
-		grp := s.apiRouter.Group(svr.PartialURL)
+		grp := s.serverRoutesDeclare(svr.PartialURL, func(sv *XdsServer) bool {
+			return sv.PartialURL == svr.PartialURL
+		})
 		svr.SetAPIRouterGroup(grp)
+		if svr.Name != "" {
+			name := svr.Name
+			s.serverRoutesDeclare("/servers/"+name, func(sv *XdsServer) bool {
+				return sv.Name == name
+			})
+		}
 
-		// Declare passthrough routes
-		s.sdksPassthroughInit(svr)
+		// Declare routes handled by agent for this server
+		s.serversRoutesInit(svr)
+
+		// Forward all other requests to XDS Server
+		svr.PassthroughInit()
 
 		// Register callback on Connection
 		svr.ConnectOn(func(server *XdsServer) error {
 
-			// Add server to list
+			// Server may have been deleted while (re)connecting
+			if !s.isListedServer(svr) {
+				server.Close()
+				return fmt.Errorf("XDS Server %s has been deleted", server.ID)
+			}
+
+			// Add server to list (and remove entry registered with a
+			// temporary ID, see incompatible server below)
+			registered := s.isRegisteredServer(svr)
+			for id, sv := range s.xdsServers {
+				if sv == svr && id != server.ID {
+					delete(s.xdsServers, id)
+				}
+			}
 			s.xdsServers[server.ID] = svr
 
+			// Server added at runtime is saved once registered
+			if !registered && svr.conf.Origin == "" && !svr.conf.Restored {
+				s.saveServersConf()
+			}
+
 			// Register event forwarder
 			if err := s.sdksEventsForwardInit(server); err != nil {
 				s.Log.Errorf("XDS Server %v - sdk event forwarding error: %v", server.ID, err)
@@ -146,6 +216,11 @@ func (s *APIService) AddXdsServer(cfg xdsconfig.XDSServerConf) (*XdsServer, erro
 	// Established connection
 	err := svr.Connect()
 
+	// Keep incompatible server in list, so its state is visible in config
+	if err != nil && svr.IncompatibleReason != "" {
+		s.xdsServers[svr.ID] = svr
+	}
+
 	// Delete temporary ID with it has been replaced by right Server ID
 	if tempoID && !svr.IsTempoID() {
 		delete(s.xdsServers, cfg.ID)
@@ -156,10 +231,46 @@ func (s *APIService) AddXdsServer(cfg xdsconfig.XDSServerConf) (*XdsServer, erro
 
 // DelXdsServer Delete an XDS Server from the list of a server
 func (s *APIService) DelXdsServer(id string) error {
-	if _, exist := s.xdsServers[id]; !exist {
+	svr, exist := s.findListedServer(id)
+	if !exist {
 		return fmt.Errorf("Unknown Server ID %s", id)
 	}
-	// Don't really delete, just disable it
-	s.xdsServers[id].Close()
+
+	// Also stop background reconnection
+	svr.Close()
+
+	// Note that routes of server cannot be removed from router, but they
+	// return an error as server is no longer listed (see serverRoutesDeclare)
+	for key, sv := range s.xdsServers {
+		if sv == svr {
+			delete(s.xdsServers, key)
+		}
+	}
+	for i, sv := range s.svrList {
+		if sv == svr {
+			s.svrList = append(s.svrList[:i], s.svrList[i+1:]...)
+			break
+		}
+	}
 	return nil
 }
+
+// saveServersConf Save list of XDS Servers, so runtime changes are kept
+// across agent restarts. A server added at runtime is not saved until it has
+// been registered, so that an invalid url is not restored on next start.
+func (s *APIService) saveServersConf() {
+	s.saveMutex.Lock()
+	defer s.saveMutex.Unlock()
+
+	list := []xdsconfig.XDSServerConf{}
+	for _, svr := range s.svrList {
+		conf := svr.GetConf()
+		if conf.Origin == "" && !conf.Restored && !s.isRegisteredServer(svr) {
+			continue
+		}
+		list = append(list, conf)
+	}
+	if err := s.Config.SaveServers(list); err != nil {
+		s.Log.Errorf("Cannot save XDS Servers configuration: %v", err)
+	}
+}
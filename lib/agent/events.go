@@ -22,6 +22,7 @@ import (
 	"time"
 
 	"github.com/iotbzh/xds-agent/lib/xaapiv1"
+	"github.com/syncthing/syncthing/lib/sync"
 )
 
 // EventDef Definition on one event
@@ -33,6 +34,7 @@ type EventDef struct {
 type Events struct {
 	*Context
 	eventsMap map[string]*EventDef
+	mutex     sync.Mutex // protect sessions IDs of eventsMap
 }
 
 // NewEvents creates an instance of Events
@@ -46,6 +48,7 @@ func NewEvents(ctx *Context) *Events {
 	return &Events{
 		Context:   ctx,
 		eventsMap: evMap,
+		mutex:     sync.NewMutex(),
 	}
 }
 
@@ -63,6 +66,8 @@ func (e *Events) Register(evName, sessionID string) error {
 		}
 		evs = []string{evName}
 	}
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
 	for _, ev := range evs {
 		e.eventsMap[ev].sids[sessionID]++
 	}
@@ -78,15 +83,29 @@ func (e *Events) UnRegister(evName, sessionID string) error {
 		}
 		evs = []string{evName}
 	}
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
 	for _, ev := range evs {
 		if _, exist := e.eventsMap[ev].sids[sessionID]; exist {
 			delete(e.eventsMap[ev].sids, sessionID)
-			break
 		}
 	}
 	return nil
 }
 
+// GetRegistered returns the list of events registered by a session
+func (e *Events) GetRegistered(sessionID string) []string {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	evs := []string{}
+	for _, ev := range xaapiv1.EVTAllList {
+		if _, exist := e.eventsMap[ev].sids[sessionID]; exist {
+			evs = append(evs, ev)
+		}
+	}
+	return evs
+}
+
 // Emit Used to manually emit an event
 func (e *Events) Emit(evName string, data interface{}, fromSid string) error {
 	var firstErr error
@@ -97,9 +116,20 @@ func (e *Events) Emit(evName string, data interface{}, fromSid string) error {
 
 	e.LogSillyf("Emit Event %s: %v", evName, data)
 
+	fromUser := ""
+	if fromSid != "" {
+		fromUser = e.webServer.sessions.GetIdentityName(fromSid)
+	}
+
+	e.mutex.Lock()
+	sids := []string{}
+	for sid := range e.eventsMap[evName].sids {
+		sids = append(sids, sid)
+	}
+	e.mutex.Unlock()
+
 	firstErr = nil
-	evm := e.eventsMap[evName]
-	for sid := range evm.sids {
+	for _, sid := range sids {
 		so := e.webServer.sessions.IOSocketGet(sid)
 		if so == nil {
 			if firstErr == nil {
@@ -110,6 +140,7 @@ func (e *Events) Emit(evName string, data interface{}, fromSid string) error {
 		msg := xaapiv1.EventMsg{
 			Time:          time.Now().String(),
 			FromSessionID: fromSid,
+			FromUser:      fromUser,
 			Type:          evName,
 			Data:          data,
 		}
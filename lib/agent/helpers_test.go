@@ -0,0 +1,119 @@
+/*
+ * Copyright (C) 2017 "IoT.bzh"
+ * Author Sebastien Douheret <sebastien@iot.bzh>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package agent
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/iotbzh/xds-agent/lib/xdsconfig"
+)
+
+// newTestContext returns an agent context without syncthing whose state
+// files are created in a temporary directory. Web server doesn't listen and
+// middlewares are not installed (see serveAPI and newTestRouterContext).
+// cleanup must be called at the end of test.
+func newTestContext(t *testing.T) (ctx *Context, cleanup func()) {
+	dir, err := ioutil.TempDir("", "xds-agent-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	log := logrus.New()
+	log.Out = ioutil.Discard
+
+	ctx = &Context{
+		ProgName:   "xds-agent-test",
+		Log:        log,
+		LogSillyf:  func(format string, args ...interface{}) {},
+		xdsServers: make(map[string]*XdsServer),
+		Exit:       make(chan os.Signal, 1),
+	}
+	ctx.Config = &xdsconfig.Config{
+		AgentUID:      "agent-test",
+		Version:       "0.0.0",
+		APIVersion:    xdsconfig.DefaultAPIVersion,
+		Log:           log,
+		LogVerboseOut: ioutil.Discard,
+		FileConf: xdsconfig.FileConfig{
+			StateFile: filepath.Join(dir, "agent-state.json"),
+			Auth: xdsconfig.AuthConf{
+				TokensFile: filepath.Join(dir, "tokens.json"),
+			},
+			ProjectsConf: xdsconfig.ProjectsConf{
+				CacheFile:      filepath.Join(dir, "projects-cache.json"),
+				JournalFile:    filepath.Join(dir, "projects-journal.json"),
+				FilesStateFile: filepath.Join(dir, "projects-files.json"),
+				ConfFiles:      []string{"conf"},
+			},
+		},
+	}
+	ctx.events = NewEvents(ctx)
+	ctx.tokens = NewTokens(ctx)
+	ctx.webServer = NewWebServer(ctx)
+	ctx.sessions = NewClientSessions(ctx, "0")
+	ctx.projects = NewProjects(ctx, nil)
+
+	return ctx, func() {
+		ctx.sessions.Stop()
+		os.RemoveAll(dir)
+	}
+}
+
+// newTestRouterContext returns a test context whose routes go through the
+// middlewares installed by Serve (rate limits, authentication and CSRF).
+// setup is called to change settings before middlewares are installed.
+func newTestRouterContext(t *testing.T, setup func(conf *xdsconfig.FileConfig)) (ctx *Context, cleanup func()) {
+	ctx, cleanup = newTestContext(t)
+	if setup != nil {
+		setup(&ctx.Config.FileConf)
+	}
+	ctx.webServer.rateLimits = NewRateLimits(ctx.Config.FileConf.RateLimit)
+	ctx.webServer.middlewaresInit()
+	ctx.webServer.api = NewAPIV1(ctx)
+	return ctx, cleanup
+}
+
+// serveRequest returns response of a request sent to agent web server
+func serveRequest(ctx *Context, req *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	ctx.webServer.router.ServeHTTP(w, req)
+	return w
+}
+
+// serveAPI returns status of a request sent to agent REST API
+func serveAPI(ctx *Context, method, path string) int {
+	code, _ := serveAPIJSON(ctx, method, path, "")
+	return code
+}
+
+// serveAPIJSON returns status and body of a request (with a JSON body) sent to
+// agent REST API
+func serveAPIJSON(ctx *Context, method, path, body string) (int, string) {
+	req, _ := http.NewRequest(method, apiBaseURL+path, strings.NewReader(body))
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	w := serveRequest(ctx, req)
+	return w.Code, w.Body.String()
+}
@@ -24,6 +24,7 @@ import (
 	st "github.com/iotbzh/xds-agent/lib/syncthing"
 	"github.com/iotbzh/xds-agent/lib/xaapiv1"
 	"github.com/iotbzh/xds-server/lib/xsapiv1"
+	uuid "github.com/satori/go.uuid"
 )
 
 // IPROJECT interface implementation for syncthing projects
@@ -33,15 +34,18 @@ type STProject struct {
 	*Context
 	server   *XdsServer
 	folder   *xsapiv1.FolderConfig
-	eventIDs []int
+	svrEvtID uuid.UUID      // XDS Server events registration
+	eventIDs map[string]int // local Syncthing events registrations
+	detached bool           // set when project has been moved to another server
 }
 
 // NewProjectST Create a new instance of STProject
 func NewProjectST(ctx *Context, svr *XdsServer) *STProject {
 	p := STProject{
-		Context: ctx,
-		server:  svr,
-		folder:  &xsapiv1.FolderConfig{},
+		Context:  ctx,
+		server:   svr,
+		folder:   &xsapiv1.FolderConfig{},
+		eventIDs: make(map[string]int),
 	}
 	return &p
 }
@@ -106,10 +110,12 @@ func (p *STProject) Setup(prj xaapiv1.ProjectConfig) (*xaapiv1.ProjectConfig, er
 
 	// Register events to update folder status
 	// Register to XDS Server events
-	if _, err := p.server.EventOn(xsapiv1.EVTFolderStateChange, "", p._cbServerFolderChanged); err != nil {
+	evtID, err := p.server.EventOn(xsapiv1.EVTFolderStateChange, "", p._cbServerFolderChanged)
+	if err != nil {
 		p.Log.Errorf("XDS Server EventOn '%s' failed: %v", xsapiv1.EVTFolderStateChange, err)
 		return svrPrj, err
 	}
+	p.svrEvtID = evtID
 
 	// Register to Local Syncthing events
 	for _, evName := range []string{st.EventStateChanged, st.EventFolderPaused} {
@@ -117,7 +123,7 @@ func (p *STProject) Setup(prj xaapiv1.ProjectConfig) (*xaapiv1.ProjectConfig, er
 		if err != nil {
 			return nil, err
 		}
-		p.eventIDs = append(p.eventIDs, evID)
+		p.eventIDs[evName] = evID
 	}
 
 	return svrPrj, nil
@@ -130,11 +136,25 @@ func (p *STProject) Update(prj xaapiv1.ProjectConfig) (*xaapiv1.ProjectConfig, e
 		return nil, fmt.Errorf("Invalid id")
 	}
 
+	oldPath := p.folder.ClientPath
 	err := p.server.FolderUpdate(p.server.ProjectToFolder(prj), p.folder)
 	if err != nil {
 		return nil, err
 	}
 
+	// Re-point local Syncthing folder when client path has been changed
+	if p.folder.ClientPath != oldPath && p.folder.ClientPath == prj.ClientPath {
+		_, err = p.SThg.FolderChange(st.FolderChangeArg{
+			ID:           p.folder.ID,
+			Label:        p.folder.Label,
+			RelativePath: p.folder.ClientPath,
+			SyncThingID:  p.server.ServerConfig.Builder.SyncThingID,
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	return p.GetProject(), nil
 }
 
@@ -161,9 +181,30 @@ func (p *STProject) IsInSync() (bool, error) {
 ** Private functions
 ***/
 
+// _detach Stop processing and un-register events of a project that has been
+// migrated (or of a project object dropped by migration rollback)
+func (p *STProject) _detach() {
+	p.detached = true
+
+	if p.svrEvtID != uuid.Nil {
+		p.server.EventOff(xsapiv1.EVTFolderStateChange, p.svrEvtID)
+		p.svrEvtID = uuid.Nil
+	}
+	for evName, evID := range p.eventIDs {
+		if err := p.SThg.Events.UnRegister(evName, evID); err != nil {
+			p.Log.Debugf("Cannot un-register Syncthing event %s: %v", evName, err)
+		}
+		delete(p.eventIDs, evName)
+	}
+}
+
 // callback use to update (XDS Server) folder IsInSync status
 
 func (p *STProject) _cbServerFolderChanged(pData interface{}, data interface{}) error {
+	if p.detached {
+		return nil
+	}
+
 	evt := xsapiv1.EventMsg{}
 	d, err := json.Marshal(data)
 	if err != nil {
@@ -200,6 +241,9 @@ func (p *STProject) _cbServerFolderChanged(pData interface{}, data interface{})
 
 // callback use to update IsInSync status
 func (p *STProject) _cbLocalSTEvents(ev st.Event, data *st.EventsCBData) {
+	if p.detached {
+		return
+	}
 
 	inSync := p.folder.DataCloudSync.STLocIsInSync
 	sts := p.folder.DataCloudSync.STLocStatus
@@ -0,0 +1,330 @@
+/*
+ * Copyright (C) 2017 "IoT.bzh"
+ * Author Sebastien Douheret <sebastien@iot.bzh>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package agent
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/iotbzh/xds-agent/lib/xaapiv1"
+	common "github.com/iotbzh/xds-common/golib"
+)
+
+// projectBundleVersion Version of bundle format generated by Export
+const projectBundleVersion = 1
+
+// Variables of xds-project.conf that depend on agent and project, so they are
+// not part of bundle environment
+var projectConfOwnVars = []string{"XDS_AGENT_URL", "XDS_PROJECT_ID", "XDS_SDK_ID"}
+
+// Export Return portable definition of a project, client and server paths are
+// set relative to clientRoot and serverRoot (default roots of agent config
+// are used when empty)
+func (p *Projects) Export(id, clientRoot, serverRoot string) (xaapiv1.ProjectBundle, error) {
+	prj, exist := p.GetConfig(id)
+	if !exist {
+		return xaapiv1.ProjectBundle{}, fmt.Errorf("Unknown id")
+	}
+	if clientRoot == "" {
+		clientRoot = p.Config.FileConf.ProjectsConf.ClientRoot
+	}
+	if serverRoot == "" {
+		serverRoot = p.Config.FileConf.ProjectsConf.ServerRoot
+	}
+
+	b := xaapiv1.ProjectBundle{
+		Version:    projectBundleVersion,
+		Label:      prj.Label,
+		Type:       prj.Type,
+		DefaultSdk: prj.DefaultSdk,
+		ClientData: prj.ClientData,
+		ClientPath: relativePath(clientRoot, prj.ClientPath),
+		ServerPath: relativePath(serverRoot, prj.ServerPath),
+	}
+	if svr, exist := p.getXdsServer(prj.ServerID); exist {
+		b.ServerName = svr.Name
+	}
+
+	env, err := readProjectConfEnv(filepath.Join(prj.ClientPath, "xds-project.conf"))
+	if err != nil {
+		return b, err
+	}
+	for _, v := range env {
+		if !isProjectConfOwnVar(v) {
+			b.Env = append(b.Env, v)
+		}
+	}
+
+	if b.IgnorePatterns, err = readLines(filepath.Join(prj.ClientPath, ".stignore")); err != nil {
+		return b, err
+	}
+	return b, nil
+}
+
+// Import Create a new project from a bundle
+func (p *Projects) Import(args xaapiv1.ProjectImportArgs, fromSid string) (*xaapiv1.ProjectConfig, error) {
+	b := args.Bundle
+	if b.Version > projectBundleVersion {
+		return nil, fmt.Errorf("Unsupported bundle version %d", b.Version)
+	}
+
+	clientRoot := args.ClientRoot
+	if clientRoot == "" {
+		clientRoot = p.Config.FileConf.ProjectsConf.ClientRoot
+	}
+	serverRoot := args.ServerRoot
+	if serverRoot == "" {
+		serverRoot = p.Config.FileConf.ProjectsConf.ServerRoot
+	}
+
+	clientPath, err := confineClientPath(clientRoot, remapPath(b.ClientPath, args.PathMap))
+	if err != nil {
+		return nil, err
+	}
+	if err := checkProjectConfEnv(b.Env); err != nil {
+		return nil, err
+	}
+	serverPath := absolutePath(serverRoot, remapPath(b.ServerPath, args.PathMap))
+
+	serverID := args.ServerID
+	if serverID == "" {
+		serverID = xaapiv1.ServerIDAuto
+		if _, exist := p.getXdsServer(b.ServerName); exist {
+			serverID = b.ServerName
+		}
+	}
+
+	// Files of project are retrieved later (eg. by Cloud Sync), but ignore
+	// patterns must be set before first synchronization
+	if err := os.MkdirAll(clientPath, 0755); err != nil {
+		return nil, fmt.Errorf("Cannot create client path: %v", err)
+	}
+	if len(b.IgnorePatterns) > 0 {
+		ignFile := filepath.Join(clientPath, ".stignore")
+		if !common.Exists(ignFile) {
+			data := strings.Join(b.IgnorePatterns, "\n") + "\n"
+			if err := ioutil.WriteFile(ignFile, []byte(data), 0644); err != nil {
+				return nil, fmt.Errorf("Cannot write ignore patterns: %v", err)
+			}
+		}
+	}
+
+	prj, err := p.Add(xaapiv1.ProjectConfig{
+		ServerID:   serverID,
+		Label:      b.Label,
+		ClientPath: clientPath,
+		ServerPath: serverPath,
+		Type:       b.Type,
+		DefaultSdk: b.DefaultSdk,
+		ClientData: b.ClientData,
+	}, fromSid)
+	if err != nil {
+		return prj, err
+	}
+
+	if err := appendProjectConfEnv(filepath.Join(clientPath, "xds-project.conf"), b.Env); err != nil {
+		return prj, err
+	}
+	return prj, nil
+}
+
+// confineClientPath returns absolute and clean client path of a project
+// (relative path is relative to client root). Files are created in client
+// path, so it must not escape client root.
+func confineClientPath(clientRoot, path string) (string, error) {
+	clientPath := absolutePath(clientRoot, path)
+	if !filepath.IsAbs(clientPath) {
+		return "", fmt.Errorf("Cannot resolve client path %s (client root not set)", path)
+	}
+	clientPath = filepath.Clean(clientPath)
+	if clientRoot == "" || relativePath(filepath.Clean(clientRoot), clientPath) == clientPath {
+		return "", fmt.Errorf("Client path %s is not inside client root %s", clientPath, clientRoot)
+	}
+	return clientPath, nil
+}
+
+// relativePath returns path relative to root, or path unchanged when it's
+// not inside root
+func relativePath(root, path string) string {
+	if root == "" || path == "" {
+		return path
+	}
+	rel, err := filepath.Rel(root, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return path
+	}
+	return rel
+}
+
+// absolutePath returns path joined to root when path is relative
+func absolutePath(root, path string) string {
+	if root == "" || path == "" || filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(root, path)
+}
+
+// remapPath Replace longest prefix of path found in pathMap
+func remapPath(path string, pathMap map[string]string) string {
+	best := ""
+	for from := range pathMap {
+		if from == "" || len(from) <= len(best) {
+			continue
+		}
+		if path == from || strings.HasPrefix(path, strings.TrimRight(from, "/")+"/") {
+			best = from
+		}
+	}
+	if best == "" {
+		return path
+	}
+	return pathMap[best] + strings.TrimPrefix(path, best)
+}
+
+// readLines returns not empty lines of a file (nil when file doesn't exist)
+func readLines(file string) ([]string, error) {
+	if !common.Exists(file) {
+		return nil, nil
+	}
+	fd, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer fd.Close()
+
+	lines := []string{}
+	scanner := bufio.NewScanner(fd)
+	for scanner.Scan() {
+		if l := strings.TrimSpace(scanner.Text()); l != "" {
+			lines = append(lines, l)
+		}
+	}
+	return lines, scanner.Err()
+}
+
+// readProjectConfEnv returns variables exported in xds-project.conf
+// (NAME=VALUE format)
+func readProjectConfEnv(file string) ([]string, error) {
+	lines, err := readLines(file)
+	if err != nil {
+		return nil, err
+	}
+	env := []string{}
+	for _, l := range lines {
+		if !strings.HasPrefix(l, "export ") {
+			continue
+		}
+		v := strings.TrimSpace(strings.TrimPrefix(l, "export "))
+		if kv := strings.SplitN(v, "=", 2); len(kv) == 2 {
+			env = append(env, kv[0]+"="+shellUnquote(kv[1]))
+		}
+	}
+	return env, nil
+}
+
+// appendProjectConfEnv Add variables to xds-project.conf (variables already
+// set in file are not changed)
+func appendProjectConfEnv(file string, env []string) error {
+	if len(env) == 0 {
+		return nil
+	}
+	cur, err := readProjectConfEnv(file)
+	if err != nil {
+		return err
+	}
+	known := make(map[string]bool)
+	for _, v := range cur {
+		known[strings.SplitN(v, "=", 2)[0]] = true
+	}
+
+	fd, err := os.OpenFile(file, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0666)
+	if err != nil {
+		return fmt.Errorf("Cannot update xds-project.conf: %v", err)
+	}
+	defer fd.Close()
+	for _, v := range env {
+		name := strings.SplitN(v, "=", 2)[0]
+		if known[name] || isProjectConfOwnVar(v) {
+			continue
+		}
+		known[name] = true
+		fd.WriteString("export " + name + "=" + shellQuote(strings.TrimPrefix(v, name+"=")) + "\n")
+	}
+	return nil
+}
+
+// isProjectConfOwnVar returns true when variable (NAME=VALUE) is generated
+// by agent
+func isProjectConfOwnVar(v string) bool {
+	name := strings.SplitN(v, "=", 2)[0]
+	for _, n := range projectConfOwnVars {
+		if name == n {
+			return true
+		}
+	}
+	return false
+}
+
+// envNameRegexp Valid name of a variable exported in xds-project.conf
+var envNameRegexp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// checkProjectConfEnv returns an error when a variable (NAME=VALUE) cannot be
+// exported in xds-project.conf
+func checkProjectConfEnv(env []string) error {
+	for _, v := range env {
+		kv := strings.SplitN(v, "=", 2)
+		if len(kv) != 2 || !envNameRegexp.MatchString(kv[0]) {
+			return fmt.Errorf("Invalid environment variable '%s'", v)
+		}
+	}
+	return nil
+}
+
+// shellQuote returns value quoted so that it's never interpreted by shell
+// when xds-project.conf is sourced (unchanged when it only contains safe
+// characters)
+func shellQuote(val string) string {
+	if shellSafeRegexp.MatchString(val) {
+		return val
+	}
+	return "'" + strings.Replace(val, "'", `'\''`, -1) + "'"
+}
+
+var shellSafeRegexp = regexp.MustCompile(`^[A-Za-z0-9_@%+=:,./-]+$`)
+
+// shellUnquote returns value of a variable quoted using shellQuote (or using
+// double quotes without any expansion)
+func shellUnquote(val string) string {
+	if len(val) < 2 || val[0] != val[len(val)-1] {
+		return val
+	}
+	switch val[0] {
+	case '\'':
+		return strings.Replace(val[1:len(val)-1], `'\''`, "'", -1)
+	case '"':
+		if !strings.ContainsAny(val[1:len(val)-1], "$`\\\"") {
+			return val[1 : len(val)-1]
+		}
+	}
+	return val
+}
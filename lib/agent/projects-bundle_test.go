@@ -0,0 +1,105 @@
+/*
+ * Copyright (C) 2017 "IoT.bzh"
+ * Author Sebastien Douheret <sebastien@iot.bzh>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package agent
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/iotbzh/xds-agent/lib/xaapiv1"
+)
+
+func TestImportClientPathConfined(t *testing.T) {
+	ctx, cleanup := newTestContext(t)
+	defer cleanup()
+
+	root, err := ioutil.TempDir("", "xds-agent-root")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	for _, path := range []string{"../../outside", "/tmp/outside", "a/../../outside"} {
+		args := xaapiv1.ProjectImportArgs{
+			Bundle:     xaapiv1.ProjectBundle{Version: 1, Label: "prj", ClientPath: path},
+			ClientRoot: root,
+		}
+		if _, err := ctx.projects.Import(args, ""); err == nil {
+			t.Errorf("client path %s must be rejected", path)
+		}
+	}
+}
+
+func TestImportInvalidEnv(t *testing.T) {
+	ctx, cleanup := newTestContext(t)
+	defer cleanup()
+
+	root, err := ioutil.TempDir("", "xds-agent-root")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	for _, v := range []string{"A;touch x=1", "$(id)=1", "1A=1", "NOVALUE"} {
+		args := xaapiv1.ProjectImportArgs{
+			Bundle:     xaapiv1.ProjectBundle{Version: 1, Label: "prj", ClientPath: "prj", Env: []string{v}},
+			ClientRoot: root,
+		}
+		if _, err := ctx.projects.Import(args, ""); err == nil {
+			t.Errorf("variable %s must be rejected", v)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(root, "prj")); err == nil {
+		t.Errorf("client path must not be created when bundle is rejected")
+	}
+}
+
+func TestProjectConfEnvQuoting(t *testing.T) {
+	dir, err := ioutil.TempDir("", "xds-agent-conf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, projectConfFile)
+
+	env := []string{"CMD=$(touch pwned)", "SEP=a;b", "QUOTE=it's", "EMPTY=", "PLAIN=/usr/bin:/bin"}
+	if err := appendProjectConfEnv(file, env); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := ioutil.ReadFile(file)
+	for _, l := range []string{"export CMD='$(touch pwned)'\n", "export SEP='a;b'\n", "export QUOTE='it'\\''s'\n", "export EMPTY=''\n", "export PLAIN=/usr/bin:/bin\n"} {
+		if !strings.Contains(string(data), l) {
+			t.Errorf("line %q not found in:\n%s", l, data)
+		}
+	}
+
+	res, err := readProjectConfEnv(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res) != len(env) {
+		t.Fatalf("got %v, want %v", res, env)
+	}
+	for i := range env {
+		if res[i] != env[i] {
+			t.Errorf("got %q, want %q", res[i], env[i])
+		}
+	}
+}
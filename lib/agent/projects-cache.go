@@ -0,0 +1,183 @@
+/*
+ * Copyright (C) 2017 "IoT.bzh"
+ * Author Sebastien Douheret <sebastien@iot.bzh>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package agent
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/iotbzh/xds-agent/lib/xaapiv1"
+	common "github.com/iotbzh/xds-common/golib"
+)
+
+// Projects cache
+//
+// Last known configuration of all projects is saved locally, so that projects
+// hold by a server that is not reachable (eg. at agent startup) are still
+// listed (with StatusOffline status). When server connects, cached projects
+// are reconciled with the ones really hold by server.
+
+// loadCache Load projects cache file, all cached projects are offline until
+// their server is connected
+func (p *Projects) loadCache() error {
+	file := p.Config.FileConf.ProjectsConf.CacheFile
+	if file == "" || !common.Exists(file) {
+		return nil
+	}
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	prjs := []xaapiv1.ProjectConfig{}
+	if err := json.Unmarshal(data, &prjs); err != nil {
+		return fmt.Errorf("Invalid projects cache file %s: %v", file, err)
+	}
+
+	pjMutex.Lock()
+	defer pjMutex.Unlock()
+	for _, prj := range prjs {
+		if prj.ID == "" {
+			continue
+		}
+		if _, exist := p.projects[prj.ID]; exist {
+			continue
+		}
+		prj.Status = xaapiv1.StatusOffline
+		prj.IsInSync = false
+		p.offline[prj.ID] = prj
+	}
+	p.Log.Infof("Number of cached Projects: %d", len(p.offline))
+	return nil
+}
+
+// saveCacheUnsafe Save configuration of all projects (must be called with
+// pjMutex locked)
+func (p *Projects) saveCacheUnsafe() {
+	file := p.Config.FileConf.ProjectsConf.CacheFile
+	if file == "" {
+		return
+	}
+
+	prjs := []xaapiv1.ProjectConfig{}
+	for _, fc := range p.projects {
+		prjs = append(prjs, *(*fc).GetProject())
+	}
+	for _, prj := range p.offline {
+		prjs = append(prjs, prj)
+	}
+
+	data, err := json.MarshalIndent(prjs, "", "    ")
+	if err == nil {
+		err = os.MkdirAll(filepath.Dir(file), 0700)
+	}
+	if err == nil {
+		tmpFile := file + ".tmp"
+		if err = ioutil.WriteFile(tmpFile, data, 0600); err == nil {
+			err = os.Rename(tmpFile, file)
+		}
+	}
+	if err != nil {
+		p.Log.Errorf("Cannot save projects cache: %v", err)
+	}
+}
+
+// _reconcileCache Compare cached projects of svr with the ones loaded from
+// this server and notify clients about differences
+// (must be called with pjMutex locked)
+func (p *Projects) _reconcileCache(svr *XdsServer, known map[string]bool) {
+	for id, cPrj := range p.offline {
+		if cPrj.ServerID != svr.ID || p._hasPendingOp(id) {
+			// Projects with pending operations are handled by replayJournal
+			continue
+		}
+		delete(p.offline, id)
+
+		fc, exist := p.projects[id]
+		if !exist {
+			// Project deleted on server side while it was offline
+			p.Log.Infof("Cached project %s no longer exists on server %s", id, svr.ID)
+			if err := p.events.Emit(xaapiv1.EVTProjectDelete, cPrj, ""); err != nil {
+				p.Log.Warningf("Cannot notify project deletion: %v", err)
+			}
+			continue
+		}
+
+		// Server config is the reference, only notify changes
+		prj := *(*fc).GetProject()
+		if prj.Label != cPrj.Label || prj.ClientData != cPrj.ClientData || prj.DefaultSdk != cPrj.DefaultSdk ||
+			prj.ClientPath != cPrj.ClientPath || prj.ServerPath != cPrj.ServerPath {
+			p.Log.Infof("Cached project %s differs from server %s config", id, svr.ID)
+		}
+		if err := p.events.Emit(xaapiv1.EVTProjectChange, prj, ""); err != nil {
+			p.Log.Warningf("Cannot notify project change: %v", err)
+		}
+	}
+
+	// Projects created on server side while it was unreachable
+	for id, fc := range p.projects {
+		if (*fc).GetServer() != svr || known[id] {
+			continue
+		}
+		if err := p.events.Emit(xaapiv1.EVTProjectAdd, *(*fc).GetProject(), ""); err != nil {
+			p.Log.Warningf("Cannot notify project add: %v", err)
+		}
+	}
+
+	p.saveCacheUnsafe()
+}
+
+// _deleteCached Remove a cached project from local cache only (used when its
+// server is no longer declared), pending operations of project are dropped
+// (must be called with pjMutex locked)
+func (p *Projects) _deleteCached(prj xaapiv1.ProjectConfig, fromSid string) xaapiv1.ProjectConfig {
+	p.Log.Infof("Remove cached project %s of unknown server %s", prj.ID, prj.ServerID)
+
+	delete(p.offline, prj.ID)
+	p.saveCacheUnsafe()
+
+	ops := []projectOp{}
+	for _, op := range p.journal {
+		if op.ProjectID != prj.ID {
+			ops = append(ops, op)
+		}
+	}
+	if len(ops) != len(p.journal) {
+		p.journal = ops
+		p.saveJournalUnsafe()
+	}
+	p._removeFiles(prj)
+
+	if err := p.events.Emit(xaapiv1.EVTProjectDelete, prj, fromSid); err != nil {
+		p.Log.Warningf("Cannot notify project deletion: %v", err)
+	}
+	return prj
+}
+
+// projectPublicConfig Return public configuration of a project, with
+// StatusOffline status when its server is not connected
+func projectPublicConfig(fc *IPROJECT) xaapiv1.ProjectConfig {
+	prj := *(*fc).GetProject()
+	if svr := (*fc).GetServer(); svr != nil && (svr.Disabled || !svr.Connected) {
+		prj.Status = xaapiv1.StatusOffline
+		prj.IsInSync = false
+	}
+	return prj
+}
@@ -0,0 +1,279 @@
+/*
+ * Copyright (C) 2017 "IoT.bzh"
+ * Author Sebastien Douheret <sebastien@iot.bzh>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package agent
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/iotbzh/xds-agent/lib/xaapiv1"
+	common "github.com/iotbzh/xds-common/golib"
+)
+
+// projectConfFile Name of file created in ClientPath of projects
+const projectConfFile = "xds-project.conf"
+
+const defaultDiscoverMaxDepth = 5
+
+// Discover Scan root directory for xds-project.conf files and match them
+// against projects known by connected servers. Projects listed in args.Apply
+// are registered (unknown project) or re-linked (project known for another
+// client path), then their xds-project.conf file is fixed up. Projects of
+// offline servers are only reported.
+func (p *Projects) Discover(args xaapiv1.ProjectDiscoverArgs, fromSid string) ([]xaapiv1.DiscoveredProject, error) {
+	root := filepath.Clean(args.Root)
+	if !filepath.IsAbs(root) {
+		return nil, fmt.Errorf("Root directory must be an absolute path")
+	}
+	if st, err := os.Stat(root); err != nil || !st.IsDir() {
+		return nil, fmt.Errorf("Invalid root directory %s", args.Root)
+	}
+	maxDepth := args.MaxDepth
+	if maxDepth <= 0 {
+		maxDepth = defaultDiscoverMaxDepth
+	}
+
+	files, err := findProjectConfFiles(root, maxDepth)
+	if err != nil {
+		return nil, err
+	}
+
+	apply := make(map[string]bool)
+	for _, path := range args.Apply {
+		apply[filepath.Clean(path)] = true
+	}
+
+	res := []xaapiv1.DiscoveredProject{}
+	for _, file := range files {
+		dp := p._discoverMatch(file)
+		if apply[dp.ClientPath] {
+			p._discoverApply(&dp, args, fromSid)
+		}
+		res = append(res, dp)
+	}
+	return res, nil
+}
+
+// findProjectConfFiles Return xds-project.conf files found under root (hidden
+// directories and directories deeper than maxDepth are skipped)
+func findProjectConfFiles(root string, maxDepth int) ([]string, error) {
+	files := []string{}
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			// Skip unreadable directories
+			if info != nil && info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.IsDir() {
+			rel, _ := filepath.Rel(root, path)
+			if path != root && (strings.HasPrefix(info.Name(), ".") || strings.Count(rel, string(filepath.Separator)) >= maxDepth) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.Name() == projectConfFile {
+			files = append(files, path)
+		}
+		return nil
+	})
+	return files, err
+}
+
+// _discoverMatch Parse a xds-project.conf file and find matching project
+func (p *Projects) _discoverMatch(file string) xaapiv1.DiscoveredProject {
+	dp := xaapiv1.DiscoveredProject{
+		ConfFile:   file,
+		ClientPath: filepath.Dir(file),
+		Action:     xaapiv1.DiscoverActionRegister,
+	}
+
+	env, err := readProjectConfEnv(file)
+	if err != nil {
+		dp.Error = err.Error()
+		return dp
+	}
+	for _, v := range env {
+		kv := strings.SplitN(v, "=", 2)
+		switch kv[0] {
+		case "XDS_PROJECT_ID":
+			dp.ProjectID = kv[1]
+		case "XDS_SDK_ID":
+			dp.SdkID = kv[1]
+		case "XDS_AGENT_URL":
+			dp.AgentURL = kv[1]
+		}
+	}
+	if dp.ProjectID == "" {
+		return dp
+	}
+
+	pjMutex.Lock()
+	defer pjMutex.Unlock()
+	fc, exist := p.projects[dp.ProjectID]
+	if !exist {
+		// Project of an unreachable server must not be registered twice
+		if prj, exist := p.offline[dp.ProjectID]; exist {
+			dp.Project = &prj
+			dp.Action = xaapiv1.DiscoverActionOffline
+		}
+		return dp
+	}
+	prj := *(*fc).GetProject()
+	dp.Project = &prj
+	if svr := (*fc).GetServer(); svr.Disabled || !svr.Connected {
+		dp.Action = xaapiv1.DiscoverActionOffline
+		return dp
+	}
+	if filepath.Clean(prj.ClientPath) == dp.ClientPath {
+		dp.Action = xaapiv1.DiscoverActionNone
+	} else {
+		dp.Action = xaapiv1.DiscoverActionRelink
+	}
+	return dp
+}
+
+// _discoverApply Register or re-link a discovered project and fix up its
+// xds-project.conf file
+func (p *Projects) _discoverApply(dp *xaapiv1.DiscoveredProject, args xaapiv1.ProjectDiscoverArgs, fromSid string) {
+	if dp.Error != "" {
+		return
+	}
+
+	var newPrj xaapiv1.ProjectConfig
+	switch dp.Action {
+	case xaapiv1.DiscoverActionNone:
+		// Only fix up file
+		newPrj = *dp.Project
+
+	case xaapiv1.DiscoverActionRelink:
+		// Project directory has been moved on client side
+		prj, err := p.Relink(dp.Project.ID, dp.ClientPath, fromSid)
+		if err != nil {
+			dp.Error = err.Error()
+			return
+		}
+		newPrj = *prj
+
+	case xaapiv1.DiscoverActionOffline:
+		dp.Error = fmt.Sprintf("XDS Server %s of project is offline", dp.Project.ServerID)
+		return
+
+	case xaapiv1.DiscoverActionRegister:
+		cfg := xaapiv1.ProjectConfig{
+			ServerID:   args.ServerID,
+			Label:      filepath.Base(dp.ClientPath),
+			ClientPath: dp.ClientPath,
+			Type:       args.Type,
+			DefaultSdk: dp.SdkID,
+		}
+		if cfg.ServerID == "" {
+			cfg.ServerID = xaapiv1.ServerIDAuto
+		}
+		if cfg.Type == "" {
+			cfg.Type = xaapiv1.TypeCloudSync
+		}
+		if cfg.Type == xaapiv1.TypePathMap {
+			dp.Error = "Server path of a path-mapping project cannot be discovered"
+			return
+		}
+		prj, err := p.Add(cfg, fromSid)
+		if err != nil {
+			dp.Error = err.Error()
+			return
+		}
+		newPrj = *prj
+	}
+
+	pjMutex.Lock()
+	agentURL := p.agentURL(newPrj.ID)
+	pjMutex.Unlock()
+	vars := map[string]string{
+		"XDS_AGENT_URL":  agentURL,
+		"XDS_PROJECT_ID": newPrj.ID,
+	}
+	if dp.SdkID == "" && newPrj.DefaultSdk != "" {
+		vars["XDS_SDK_ID"] = newPrj.DefaultSdk
+	}
+	if err := setProjectConfVars(dp.ConfFile, vars); err != nil {
+		dp.Error = err.Error()
+		return
+	}
+	dp.Project = &newPrj
+	dp.Applied = true
+}
+
+// setProjectConfVars Set value of variables exported in xds-project.conf
+// (existing lines are replaced, missing variables are added and variables
+// with an empty value are commented out)
+func setProjectConfVars(file string, vars map[string]string) error {
+	data, err := ioutil.ReadFile(file)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	done := make(map[string]bool)
+	lines := strings.Split(strings.TrimRight(string(data), "\n"), "\n")
+	if len(data) == 0 {
+		lines = []string{}
+	}
+	for i, l := range lines {
+		decl := strings.TrimSpace(l)
+		decl = strings.TrimPrefix(decl, "#")
+		if !strings.HasPrefix(decl, "export ") {
+			continue
+		}
+		name := strings.SplitN(strings.TrimPrefix(decl, "export "), "=", 2)[0]
+		if val, exist := vars[name]; exist && !done[name] {
+			lines[i] = projectConfLine(name, val)
+			done[name] = true
+		}
+	}
+	for _, name := range projectConfOwnVars {
+		if val, exist := vars[name]; exist && !done[name] {
+			lines = append(lines, projectConfLine(name, val))
+			done[name] = true
+		}
+	}
+	for name, val := range vars {
+		if !done[name] {
+			lines = append(lines, projectConfLine(name, val))
+		}
+	}
+
+	mode := os.FileMode(0666)
+	if st, err := os.Stat(file); err == nil {
+		mode = st.Mode().Perm()
+	}
+	if !common.Exists(filepath.Dir(file)) {
+		return fmt.Errorf("Directory of %s doesn't exist", file)
+	}
+	return writeFileIfChanged(file, []byte(strings.Join(lines, "\n")+"\n"), mode)
+}
+
+// projectConfLine returns export line of a variable of xds-project.conf
+func projectConfLine(name, val string) string {
+	if val == "" {
+		return "#export " + name + "=???"
+	}
+	return "export " + name + "=" + shellQuote(val)
+}
@@ -0,0 +1,163 @@
+/*
+ * Copyright (C) 2017 "IoT.bzh"
+ * Author Sebastien Douheret <sebastien@iot.bzh>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package agent
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/iotbzh/xds-agent/lib/xaapiv1"
+)
+
+// fakeProject IPROJECT implementation that only keeps project config
+type fakeProject struct {
+	server *XdsServer
+	prj    xaapiv1.ProjectConfig
+}
+
+func (f *fakeProject) Add(cfg xaapiv1.ProjectConfig) (*xaapiv1.ProjectConfig, error) {
+	f.prj = cfg
+	return f.GetProject(), nil
+}
+func (f *fakeProject) Setup(prj xaapiv1.ProjectConfig) (*xaapiv1.ProjectConfig, error) {
+	return f.Add(prj)
+}
+func (f *fakeProject) Delete() error { return nil }
+func (f *fakeProject) GetProject() *xaapiv1.ProjectConfig {
+	prj := f.prj
+	prj.ServerID = f.server.ID
+	return &prj
+}
+func (f *fakeProject) Update(prj xaapiv1.ProjectConfig) (*xaapiv1.ProjectConfig, error) {
+	return f.Add(prj)
+}
+func (f *fakeProject) GetServer() *XdsServer   { return f.server }
+func (f *fakeProject) Sync() error             { return nil }
+func (f *fakeProject) IsInSync() (bool, error) { return true, nil }
+
+// writeConf creates dir/xds-project.conf with given content
+func writeConf(t *testing.T, dir, content string) string {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(dir, projectConfFile)
+	if err := ioutil.WriteFile(file, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func TestFindProjectConfFiles(t *testing.T) {
+	root, err := ioutil.TempDir("", "xds-agent-discover")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	want := []string{
+		writeConf(t, filepath.Join(root, "a"), ""),
+		writeConf(t, filepath.Join(root, "b", "c"), ""),
+	}
+	writeConf(t, filepath.Join(root, ".hidden"), "")
+	writeConf(t, filepath.Join(root, "d", "e", "f"), "")
+
+	files, err := findProjectConfFiles(root, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(files)
+	if strings.Join(files, ",") != strings.Join(want, ",") {
+		t.Errorf("found %v, want %v", files, want)
+	}
+}
+
+func TestDiscoverMatchAndApply(t *testing.T) {
+	ctx, cleanup := newTestContext(t)
+	defer cleanup()
+	p := ctx.projects
+
+	root, err := ioutil.TempDir("", "xds-agent-discover")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	online := &XdsServer{ID: "svr-online", Connected: true}
+	offline := &XdsServer{ID: "svr-offline"}
+	var fcSame, fcMoved, fcOff IPROJECT
+	fcSame = &fakeProject{server: online, prj: xaapiv1.ProjectConfig{ID: "prj-same", ClientPath: filepath.Join(root, "same")}}
+	fcMoved = &fakeProject{server: online, prj: xaapiv1.ProjectConfig{ID: "prj-moved", ClientPath: filepath.Join(root, "old")}}
+	fcOff = &fakeProject{server: offline, prj: xaapiv1.ProjectConfig{ID: "prj-off", ClientPath: filepath.Join(root, "off")}}
+	p.projects["prj-same"] = &fcSame
+	p.projects["prj-moved"] = &fcMoved
+	p.projects["prj-off"] = &fcOff
+	p.offline["prj-cached"] = xaapiv1.ProjectConfig{ID: "prj-cached", ServerID: "svr-gone"}
+
+	tests := []struct {
+		dir    string
+		id     string
+		action string
+	}{
+		{"same", "prj-same", xaapiv1.DiscoverActionNone},
+		{"new", "prj-moved", xaapiv1.DiscoverActionRelink},
+		{"off", "prj-off", xaapiv1.DiscoverActionOffline},
+		{"cached", "prj-cached", xaapiv1.DiscoverActionOffline},
+		{"unknown", "prj-unknown", xaapiv1.DiscoverActionRegister},
+		{"noid", "", xaapiv1.DiscoverActionRegister},
+	}
+	for _, tt := range tests {
+		file := writeConf(t, filepath.Join(root, tt.dir), "export XDS_PROJECT_ID="+tt.id+"\nexport MY_VAR=1\n")
+		dp := p._discoverMatch(file)
+		if dp.Error != "" || dp.Action != tt.action || dp.ProjectID != tt.id {
+			t.Errorf("%s: got action %q (id %q, error %q), want %q", tt.dir, dp.Action, dp.ProjectID, dp.Error, tt.action)
+		}
+	}
+
+	// Offline projects must not be applied
+	dp := p._discoverMatch(filepath.Join(root, "off", projectConfFile))
+	p._discoverApply(&dp, xaapiv1.ProjectDiscoverArgs{}, "")
+	if dp.Applied || dp.Error == "" {
+		t.Errorf("offline project applied")
+	}
+	if len(p.projects) != 3 {
+		t.Errorf("offline project registered again")
+	}
+
+	// Relink must update client path of existing project
+	dp = p._discoverMatch(filepath.Join(root, "new", projectConfFile))
+	p._discoverApply(&dp, xaapiv1.ProjectDiscoverArgs{}, "")
+	if !dp.Applied || dp.Error != "" {
+		t.Fatalf("relink not applied: %s", dp.Error)
+	}
+	if len(p.projects) != 3 {
+		t.Errorf("relink created a new project")
+	}
+	if prj := fcMoved.GetProject(); prj.ID != "prj-moved" || prj.ClientPath != filepath.Join(root, "new") {
+		t.Errorf("relink: project %s has client path %s", prj.ID, prj.ClientPath)
+	}
+	data, err := ioutil.ReadFile(filepath.Join(root, "new", projectConfFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "export XDS_PROJECT_ID=prj-moved") || !strings.Contains(string(data), "export MY_VAR=1") {
+		t.Errorf("relink: unexpected %s:\n%s", projectConfFile, data)
+	}
+}
@@ -0,0 +1,352 @@
+/*
+ * Copyright (C) 2017 "IoT.bzh"
+ * Author Sebastien Douheret <sebastien@iot.bzh>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package agent
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/iotbzh/xds-agent/lib/xaapiv1"
+	common "github.com/iotbzh/xds-common/golib"
+)
+
+// Projects settings files
+//
+// Agent maintains settings files (xds-project.conf and optionally other
+// formats) in ClientPath of projects: files are written when project is
+// added, re-written when project settings change and removed when project is
+// deleted (only agent variables are removed from xds-project.conf). Agent URL
+// written in files is derived from listen settings, unless a URL has been
+// explicitly set for the project. Files managed for each project and URL set
+// for its clients are saved in filesStateFile (see agent config).
+
+// projectFiles Settings files managed for a project
+type projectFiles struct {
+	Files    []string `json:"files"`
+	AgentURL string   `json:"agentUrl"` // explicitly set by user (empty for default)
+}
+
+// projectFileNames Name of file generated for each format
+var projectFileNames = map[string]string{
+	xaapiv1.ProjectFileConf:  projectConfFile,
+	xaapiv1.ProjectFileEnv:   "xds-project.env",
+	xaapiv1.ProjectFileJSON:  "xds-project.json",
+	xaapiv1.ProjectFileCMake: "CMakeUserPresets.json",
+}
+
+// loadFilesState Load settings files managed for each project
+func (p *Projects) loadFilesState() error {
+	file := p.Config.FileConf.ProjectsConf.FilesStateFile
+	if file == "" || !common.Exists(file) {
+		return nil
+	}
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	files := make(map[string]*projectFiles)
+	if err := json.Unmarshal(data, &files); err != nil || files == nil {
+		return fmt.Errorf("Invalid projects files state %s: %v", file, err)
+	}
+
+	pjMutex.Lock()
+	defer pjMutex.Unlock()
+	p.files = files
+	return nil
+}
+
+// saveFilesStateUnsafe Save settings files managed for each project
+// (must be called with pjMutex locked)
+func (p *Projects) saveFilesStateUnsafe() {
+	file := p.Config.FileConf.ProjectsConf.FilesStateFile
+	if file == "" {
+		return
+	}
+
+	data, err := json.MarshalIndent(p.files, "", "    ")
+	if err == nil {
+		err = os.MkdirAll(filepath.Dir(file), 0700)
+	}
+	if err == nil {
+		tmpFile := file + ".tmp"
+		if err = ioutil.WriteFile(tmpFile, data, 0600); err == nil {
+			err = os.Rename(tmpFile, file)
+		}
+	}
+	if err != nil {
+		p.Log.Errorf("Cannot save projects files state: %v", err)
+	}
+}
+
+// checkFileFormats returns an error when a format is not supported
+func checkFileFormats(files []string) error {
+	for _, f := range files {
+		if _, exist := projectFileNames[f]; !exist {
+			return fmt.Errorf("Unsupported project file format '%s'", f)
+		}
+	}
+	return nil
+}
+
+// _filesOf returns settings files managed for project id, files is used when
+// project is not managed yet (nil for default formats of agent config)
+// (must be called with pjMutex locked)
+func (p *Projects) _filesOf(id string, files []string, agentURL string) *projectFiles {
+	pf, exist := p.files[id]
+	if !exist {
+		if files == nil {
+			files = p.Config.FileConf.ProjectsConf.ConfFiles
+		}
+		pf = &projectFiles{Files: files}
+		p.files[id] = pf
+	}
+	if agentURL != "" {
+		pf.AgentURL = agentURL
+	}
+	return pf
+}
+
+// _withFiles returns prj with list of managed settings files
+// (must be called with pjMutex locked)
+func (p *Projects) _withFiles(prj xaapiv1.ProjectConfig) xaapiv1.ProjectConfig {
+	if pf, exist := p.files[prj.ID]; exist {
+		prj.ConfFiles = pf.Files
+	}
+	return prj
+}
+
+// SetFiles Change settings files managed for a project (files no longer
+// managed are removed). Files of cached projects of offline servers are also
+// changed since they are located in ClientPath.
+func (p *Projects) SetFiles(id string, files []string, agentURL string) (*xaapiv1.ProjectConfig, error) {
+	if err := checkFileFormats(files); err != nil {
+		return nil, err
+	}
+
+	pjMutex.Lock()
+	defer pjMutex.Unlock()
+
+	var prj xaapiv1.ProjectConfig
+	if fc, exist := p.projects[id]; exist {
+		prj = projectPublicConfig(fc)
+	} else if cPrj, exist := p.offline[id]; exist {
+		prj = cPrj
+	} else {
+		return nil, fmt.Errorf("Unknown id")
+	}
+
+	pf := p._filesOf(id, files, agentURL)
+	for _, f := range pf.Files {
+		if containsString(files, f) {
+			continue
+		}
+		if err := removeProjectFile(prj, f); err != nil {
+			p.Log.Warningf("Cannot remove settings file %s of project %s: %v", f, id, err)
+		}
+	}
+	pf.Files = files
+	p.saveFilesStateUnsafe()
+
+	if err := p._writeFiles(prj); err != nil {
+		return nil, err
+	}
+	prj = p._withFiles(prj)
+	return &prj, nil
+}
+
+// agentURL returns agent URL written in settings files of a project
+// (must be called with pjMutex locked)
+func (p *Projects) agentURL(id string) string {
+	if pf, exist := p.files[id]; exist && pf.AgentURL != "" {
+		return pf.AgentURL
+	}
+	if p.webServer == nil {
+		return ""
+	}
+	return p.webServer.agentURL()
+}
+
+// _writeFiles Write all settings files managed for project
+// (must be called with pjMutex locked)
+func (p *Projects) _writeFiles(prj xaapiv1.ProjectConfig) error {
+	pf, exist := p.files[prj.ID]
+	if !exist || prj.ClientPath == "" || !common.Exists(prj.ClientPath) {
+		return nil
+	}
+	agentURL := p.agentURL(prj.ID)
+	for _, f := range pf.Files {
+		if err := writeProjectFile(prj, f, agentURL); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// _removeFiles Remove all settings files managed for project
+// (must be called with pjMutex locked)
+func (p *Projects) _removeFiles(prj xaapiv1.ProjectConfig) {
+	pf, exist := p.files[prj.ID]
+	if !exist {
+		return
+	}
+	for _, f := range pf.Files {
+		if err := removeProjectFile(prj, f); err != nil {
+			p.Log.Warningf("Cannot remove settings file %s of project %s: %v", f, prj.ID, err)
+		}
+	}
+	delete(p.files, prj.ID)
+	p.saveFilesStateUnsafe()
+}
+
+// writeProjectFile Generate one settings file of project
+func writeProjectFile(prj xaapiv1.ProjectConfig, format, agentURL string) error {
+	file := filepath.Join(prj.ClientPath, projectFileNames[format])
+
+	if format == xaapiv1.ProjectFileConf {
+		// Variables set by user in file are kept
+		if !common.Exists(file) {
+			if err := ioutil.WriteFile(file, []byte("# XDS project settings\n"), 0666); err != nil {
+				return fmt.Errorf("Cannot create %s: %v", projectConfFile, err)
+			}
+		}
+		return setProjectConfVars(file, map[string]string{
+			"XDS_AGENT_URL":  agentURL,
+			"XDS_PROJECT_ID": prj.ID,
+			"XDS_SDK_ID":     prj.DefaultSdk,
+		})
+	}
+
+	// Other formats are fully generated, so don't overwrite a file that has
+	// not been generated for this project
+	if data, err := ioutil.ReadFile(file); err == nil && !strings.Contains(string(data), prj.ID) {
+		return fmt.Errorf("File %s already exists and is not managed by agent", file)
+	}
+
+	var data []byte
+	var err error
+	switch format {
+	case xaapiv1.ProjectFileEnv:
+		data = []byte("# XDS project settings\n" +
+			"XDS_AGENT_URL=" + agentURL + "\n" +
+			"XDS_PROJECT_ID=" + prj.ID + "\n" +
+			"XDS_SDK_ID=" + prj.DefaultSdk + "\n")
+
+	case xaapiv1.ProjectFileJSON:
+		data, err = json.MarshalIndent(map[string]string{
+			"agentUrl":   agentURL,
+			"projectId":  prj.ID,
+			"sdkId":      prj.DefaultSdk,
+			"serverId":   prj.ServerID,
+			"label":      prj.Label,
+			"type":       string(prj.Type),
+			"clientPath": prj.ClientPath,
+			"serverPath": prj.ServerPath,
+		}, "", "  ")
+
+	case xaapiv1.ProjectFileCMake:
+		data, err = json.MarshalIndent(map[string]interface{}{
+			"version": 1,
+			"cmakeMinimumRequired": map[string]int{
+				"major": 3, "minor": 19, "patch": 0,
+			},
+			"configurePresets": []interface{}{
+				map[string]interface{}{
+					"name":        "xds",
+					"displayName": "XDS " + prj.Label,
+					"generator":   "Unix Makefiles",
+					"binaryDir":   "${sourceDir}/build-xds",
+					"environment": map[string]string{
+						"XDS_AGENT_URL":  agentURL,
+						"XDS_PROJECT_ID": prj.ID,
+						"XDS_SDK_ID":     prj.DefaultSdk,
+					},
+				},
+			},
+		}, "", "  ")
+	}
+	if err != nil {
+		return err
+	}
+	return writeFileIfChanged(file, data, 0666)
+}
+
+// writeFileIfChanged Write a file only when its content changes (keep
+// modification time of files that are re-generated on each server connection)
+func writeFileIfChanged(file string, data []byte, perm os.FileMode) error {
+	if cur, err := ioutil.ReadFile(file); err == nil && bytes.Equal(cur, data) {
+		return nil
+	}
+	return ioutil.WriteFile(file, data, perm)
+}
+
+// removeProjectFile Remove one settings file of project (only when it has
+// been generated for this project). xds-project.conf may contain variables
+// set by user, so only variables set by agent are removed from this file.
+func removeProjectFile(prj xaapiv1.ProjectConfig, format string) error {
+	file := filepath.Join(prj.ClientPath, projectFileNames[format])
+	data, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if !strings.Contains(string(data), prj.ID) {
+		return nil
+	}
+	if format == xaapiv1.ProjectFileConf {
+		return unsetProjectConfVars(file, projectConfOwnVars)
+	}
+	return os.Remove(file)
+}
+
+// unsetProjectConfVars Remove lines of variables (exported or commented out)
+// from xds-project.conf
+func unsetProjectConfVars(file string, names []string) error {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	lines := []string{}
+	for _, l := range strings.Split(strings.TrimRight(string(data), "\n"), "\n") {
+		decl := strings.TrimPrefix(strings.TrimSpace(l), "#")
+		name := strings.SplitN(strings.TrimPrefix(decl, "export "), "=", 2)[0]
+		if strings.HasPrefix(decl, "export ") && containsString(names, name) {
+			continue
+		}
+		lines = append(lines, l)
+	}
+	st, err := os.Stat(file)
+	if err != nil {
+		return err
+	}
+	return writeFileIfChanged(file, []byte(strings.Join(lines, "\n")+"\n"), st.Mode().Perm())
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,143 @@
+/*
+ * Copyright (C) 2017 "IoT.bzh"
+ * Author Sebastien Douheret <sebastien@iot.bzh>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package agent
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/iotbzh/xds-agent/lib/xaapiv1"
+)
+
+func TestRemoveProjectConfKeepsUserVars(t *testing.T) {
+	dir, err := ioutil.TempDir("", "xds-agent-files")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	prj := xaapiv1.ProjectConfig{ID: "prj-1234", ClientPath: dir, DefaultSdk: "sdk-1"}
+	if err := writeProjectFile(prj, xaapiv1.ProjectFileConf, "localhost:8800"); err != nil {
+		t.Fatal(err)
+	}
+	if err := appendProjectConfEnv(filepath.Join(dir, projectConfFile), []string{"MY_VAR=1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := removeProjectFile(prj, xaapiv1.ProjectFileConf); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, projectConfFile))
+	if err != nil {
+		t.Fatalf("%s must not be removed: %v", projectConfFile, err)
+	}
+	if !strings.Contains(string(data), "export MY_VAR=1") {
+		t.Errorf("user variable removed:\n%s", data)
+	}
+	if strings.Contains(string(data), "XDS_") {
+		t.Errorf("agent variables not removed:\n%s", data)
+	}
+}
+
+func TestWriteProjectFilesOnlyWhenChanged(t *testing.T) {
+	dir, err := ioutil.TempDir("", "xds-agent-files")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	prj := xaapiv1.ProjectConfig{ID: "prj-1234", ClientPath: dir, DefaultSdk: "sdk-1"}
+	past := time.Now().Add(-time.Hour).Truncate(time.Second)
+	for _, format := range []string{xaapiv1.ProjectFileConf, xaapiv1.ProjectFileEnv} {
+		file := filepath.Join(dir, projectFileNames[format])
+		if err := writeProjectFile(prj, format, "localhost:8800"); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(file, past, past); err != nil {
+			t.Fatal(err)
+		}
+
+		// Same settings (eg. on server reconnection)
+		if err := writeProjectFile(prj, format, "localhost:8800"); err != nil {
+			t.Fatal(err)
+		}
+		if fi, err := os.Stat(file); err != nil || !fi.ModTime().Equal(past) {
+			t.Errorf("%s: unchanged file must not be re-written", format)
+		}
+
+		// Settings changed
+		if err := writeProjectFile(prj, format, "localhost:8801"); err != nil {
+			t.Fatal(err)
+		}
+		if fi, err := os.Stat(file); err != nil || fi.ModTime().Equal(past) {
+			t.Errorf("%s: changed file must be re-written", format)
+		}
+	}
+}
+
+func TestSetFilesOfOfflineProject(t *testing.T) {
+	ctx, cleanup := newTestContext(t)
+	defer cleanup()
+
+	dir, err := ioutil.TempDir("", "xds-agent-files")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	p := ctx.projects
+	p.offline["prj-cached"] = xaapiv1.ProjectConfig{
+		ID:         "prj-cached",
+		ServerID:   "svr-offline",
+		ClientPath: dir,
+		Status:     xaapiv1.StatusOffline,
+	}
+
+	if _, err := p.SetFiles("prj-unknown", []string{xaapiv1.ProjectFileEnv}, ""); err == nil {
+		t.Errorf("unknown project must be rejected")
+	}
+
+	files := []string{xaapiv1.ProjectFileConf, xaapiv1.ProjectFileEnv}
+	prj, err := p.SetFiles("prj-cached", files, "http://localhost:8800")
+	if err != nil {
+		t.Fatalf("cannot set files of offline project: %v", err)
+	}
+	if len(prj.ConfFiles) != 2 || prj.Status != xaapiv1.StatusOffline {
+		t.Errorf("invalid project: %+v", prj)
+	}
+	envFile := filepath.Join(dir, projectFileNames[xaapiv1.ProjectFileEnv])
+	data, err := ioutil.ReadFile(envFile)
+	if err != nil || !strings.Contains(string(data), "XDS_AGENT_URL=http://localhost:8800") {
+		t.Fatalf("env file not written (%v):\n%s", err, data)
+	}
+
+	// Files no longer managed are removed
+	if _, err := p.SetFiles("prj-cached", []string{xaapiv1.ProjectFileConf}, ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(envFile); !os.IsNotExist(err) {
+		t.Errorf("env file not removed")
+	}
+	if _, err := os.Stat(filepath.Join(dir, projectConfFile)); err != nil {
+		t.Errorf("%s removed", projectConfFile)
+	}
+}
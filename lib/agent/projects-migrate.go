@@ -0,0 +1,205 @@
+/*
+ * Copyright (C) 2017 "IoT.bzh"
+ * Author Sebastien Douheret <sebastien@iot.bzh>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package agent
+
+import (
+	"fmt"
+	"time"
+
+	st "github.com/iotbzh/xds-agent/lib/syncthing"
+	"github.com/iotbzh/xds-agent/lib/xaapiv1"
+)
+
+const defaultMigrateSyncTimeout = 120 // in seconds
+
+// Migrate Move a project from its current XDS Server to another one
+func (p *Projects) Migrate(id string, args xaapiv1.ProjectMigrateArgs, fromSid string) (*xaapiv1.ProjectConfig, error) {
+
+	// Lookup project and target server
+	pjMutex.Lock()
+	fc, exist := p.projects[id]
+	if !exist {
+		pjMutex.Unlock()
+		return nil, fmt.Errorf("Unknown id")
+	}
+	if p.busy[id] {
+		pjMutex.Unlock()
+		return nil, fmt.Errorf("Project is being migrated")
+	}
+	// Project is kept in list during migration, other changes are refused
+	p.busy[id] = true
+	defer func() {
+		pjMutex.Lock()
+		delete(p.busy, id)
+		pjMutex.Unlock()
+	}()
+	oldFld := *fc
+	oldPrj := *oldFld.GetProject()
+	oldSvr := oldFld.GetServer()
+	pjMutex.Unlock()
+
+	var newSvr *XdsServer
+	if args.ServerID == xaapiv1.ServerIDAuto {
+		sel, err := p.selectServer(oldPrj)
+		if err != nil {
+			return nil, err
+		}
+		newSvr = sel
+	} else if newSvr, exist = p.getXdsServer(args.ServerID); !exist {
+		return nil, fmt.Errorf("Unknown Server ID %s", args.ServerID)
+	}
+
+	if newSvr.ID == oldSvr.ID {
+		return nil, fmt.Errorf("Project already hold by server %s", newSvr.ID)
+	}
+	if !newSvr.Connected || newSvr.ServerConfig == nil {
+		return nil, fmt.Errorf("Server %s not connected", newSvr.ID)
+	}
+	if b, exist := newSvr.ServerConfig.SupportedSharing[string(oldPrj.Type)]; !exist || !b {
+		return nil, fmt.Errorf("Server doesn't support project type %s", oldPrj.Type)
+	}
+
+	p.Log.Infof("Migrate project %s from server %s to %s", id, oldSvr.ID, newSvr.ID)
+
+	// Create project on new server (keep ID, label, sdk and client data)
+	newF := oldPrj
+	newF.ServerID = newSvr.ID
+	newF.Status = ""
+	newF.IsInSync = false
+	if oldPrj.Type == xaapiv1.TypePathMap {
+		if args.ServerPath != "" {
+			newF.ServerPath = args.ServerPath
+		}
+	} else {
+		newF.ServerPath = ""
+	}
+
+	newFld, err := p.newProjectObj(newF.Type, newSvr)
+	if err != nil {
+		return nil, err
+	}
+	newPrj, err := newFld.Add(newF)
+	if err != nil {
+		p._migrateRollback(oldPrj, oldSvr, newSvr, newFld, newF.ID)
+		return nil, fmt.Errorf("Cannot create project on server %s: %v", newSvr.ID, err)
+	}
+
+	// Verify first synchronization
+	timeout := args.Timeout
+	if timeout <= 0 {
+		timeout = defaultMigrateSyncTimeout
+	}
+	if err := p._migrateWaitSync(newFld, time.Duration(timeout)*time.Second); err != nil {
+		p._migrateRollback(oldPrj, oldSvr, newSvr, newFld, newPrj.ID)
+		return nil, err
+	}
+
+	// Remove project from old server
+	if err := oldSvr.FolderDelete(oldPrj.ID); err != nil {
+		p._migrateRollback(oldPrj, oldSvr, newSvr, newFld, newPrj.ID)
+		return nil, fmt.Errorf("Cannot delete project from server %s: %v", oldSvr.ID, err)
+	}
+	if oldPrj.Type == xaapiv1.TypeCloudSync && newPrj.ID != oldPrj.ID {
+		if err := p.SThg.FolderDelete(oldPrj.ID); err != nil {
+			p.Log.Warningf("Cannot delete local Syncthing folder %s: %v", oldPrj.ID, err)
+		}
+	}
+	if stp, ok := oldFld.(*STProject); ok {
+		stp._detach()
+	}
+
+	// Replace project in list
+	pjMutex.Lock()
+	delete(p.projects, oldPrj.ID)
+	p.projects[newPrj.ID] = &newFld
+	p.saveCacheUnsafe()
+	if err := p._writeFiles(*newFld.GetProject()); err != nil {
+		p.Log.Warningf("Cannot update settings files of project %s: %v", newPrj.ID, err)
+	}
+	pjMutex.Unlock()
+
+	prj := newFld.GetProject()
+	if newPrj.ID != oldPrj.ID {
+		if err := p.events.Emit(xaapiv1.EVTProjectDelete, oldPrj, fromSid); err != nil {
+			p.Log.Warningf("Cannot notify project deletion: %v", err)
+		}
+		if err := p.events.Emit(xaapiv1.EVTProjectAdd, *prj, fromSid); err != nil {
+			p.Log.Warningf("Cannot notify project add: %v", err)
+		}
+	} else if err := p.events.Emit(xaapiv1.EVTProjectChange, *prj, fromSid); err != nil {
+		p.Log.Warningf("Cannot notify project change: %v", err)
+	}
+
+	return prj, nil
+}
+
+// _migrateWaitSync Force synchronization and wait until project is in sync
+func (p *Projects) _migrateWaitSync(fld IPROJECT, timeout time.Duration) error {
+	if err := fld.Sync(); err != nil {
+		return fmt.Errorf("Cannot synchronize project: %v", err)
+	}
+
+	end := time.Now().Add(timeout)
+	for time.Now().Before(end) {
+		if prj := fld.GetProject(); prj.IsInSync {
+			return nil
+		}
+		time.Sleep(time.Second)
+	}
+	return fmt.Errorf("Project not in sync after %v", timeout)
+}
+
+// _migrateRollback Remove project from new server and restore local setup
+func (p *Projects) _migrateRollback(oldPrj xaapiv1.ProjectConfig, oldSvr, newSvr *XdsServer, newFld IPROJECT, newID string) {
+	p.Log.Infof("Rollback migration of project %s", oldPrj.ID)
+
+	if stp, ok := newFld.(*STProject); ok {
+		stp._detach()
+	}
+
+	if newID != "" {
+		if err := newSvr.FolderDelete(newID); err != nil {
+			p.Log.Debugf("Rollback: cannot delete project %s on server %s: %v", newID, newSvr.ID, err)
+		}
+	}
+
+	if oldPrj.Type != xaapiv1.TypeCloudSync || p.SThg == nil {
+		return
+	}
+
+	if newID != "" && newID != oldPrj.ID {
+		if err := p.SThg.FolderDelete(newID); err != nil {
+			p.Log.Debugf("Rollback: cannot delete local Syncthing folder %s: %v", newID, err)
+		}
+		return
+	}
+
+	// Re-point local Syncthing folder to old server device
+	if oldSvr.ServerConfig == nil {
+		p.Log.Errorf("Rollback: cannot restore Syncthing folder %s (unknown device)", oldPrj.ID)
+		return
+	}
+	if _, err := p.SThg.FolderChange(st.FolderChangeArg{
+		ID:           oldPrj.ID,
+		Label:        oldPrj.Label,
+		RelativePath: oldPrj.ClientPath,
+		SyncThingID:  oldSvr.ServerConfig.Builder.SyncThingID,
+	}); err != nil {
+		p.Log.Errorf("Rollback: cannot restore Syncthing folder %s: %v", oldPrj.ID, err)
+	}
+}
@@ -0,0 +1,385 @@
+/*
+ * Copyright (C) 2017 "IoT.bzh"
+ * Author Sebastien Douheret <sebastien@iot.bzh>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package agent
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/iotbzh/xds-agent/lib/xaapiv1"
+	common "github.com/iotbzh/xds-common/golib"
+	uuid "github.com/satori/go.uuid"
+)
+
+// Deferred projects operations
+//
+// When deferOffline is set in projects config, add/update/delete of a project
+// hold by an offline XDS Server are saved in a journal (project status is set
+// to StatusPending) and replayed in order when server connects. Result of each
+// operation is notified using EVTProjectOpDone event.
+
+// projectOp Project operation saved in journal
+type projectOp struct {
+	ID        string                `json:"id"`
+	Op        string                `json:"op"`
+	ServerID  string                `json:"serverId"`
+	ProjectID string                `json:"projectId"`
+	Config    xaapiv1.ProjectConfig `json:"config"`
+	FromSid   string                `json:"fromSid"`
+	Date      time.Time             `json:"date"`
+}
+
+// deferEnabled returns true when operations can be deferred
+func (p *Projects) deferEnabled() bool {
+	return p.Config.FileConf.ProjectsConf.DeferOffline
+}
+
+// loadJournal Load operations not yet replayed
+func (p *Projects) loadJournal() error {
+	file := p.Config.FileConf.ProjectsConf.JournalFile
+	if file == "" || !common.Exists(file) {
+		return nil
+	}
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	ops := []projectOp{}
+	if err := json.Unmarshal(data, &ops); err != nil {
+		return fmt.Errorf("Invalid projects journal file %s: %v", file, err)
+	}
+
+	pjMutex.Lock()
+	defer pjMutex.Unlock()
+	p.journal = ops
+	if len(ops) > 0 {
+		p.Log.Infof("Number of pending project operations: %d", len(ops))
+	}
+	return nil
+}
+
+// saveJournalUnsafe Save pending operations (must be called with pjMutex locked)
+func (p *Projects) saveJournalUnsafe() {
+	file := p.Config.FileConf.ProjectsConf.JournalFile
+	if file == "" {
+		return
+	}
+
+	data, err := json.MarshalIndent(p.journal, "", "    ")
+	if err == nil {
+		err = os.MkdirAll(filepath.Dir(file), 0700)
+	}
+	if err == nil {
+		tmpFile := file + ".tmp"
+		if err = ioutil.WriteFile(tmpFile, data, 0600); err == nil {
+			err = os.Rename(tmpFile, file)
+		}
+	}
+	if err != nil {
+		p.Log.Errorf("Cannot save projects journal: %v", err)
+	}
+}
+
+// _offlineServer returns the real ID of server idOrName and whether this
+// server is known to be offline
+func (p *Projects) _offlineServer(idOrName string) (string, bool) {
+	if svr, exist := p.getXdsServer(idOrName); exist {
+		return svr.ID, svr.Disabled || !svr.Connected
+	}
+	for _, prj := range p.offline {
+		if prj.ServerID == idOrName {
+			return prj.ServerID, true
+		}
+	}
+	return idOrName, false
+}
+
+// _hasPendingOp returns true when some operations on project are pending
+func (p *Projects) _hasPendingOp(id string) bool {
+	for _, op := range p.journal {
+		if op.ProjectID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// _pushOp Add an operation in journal and notify project change
+func (p *Projects) _pushOp(op projectOp, prj xaapiv1.ProjectConfig) xaapiv1.ProjectConfig {
+	op.ID = uuid.NewV1().String()
+	op.Date = time.Now()
+	p.journal = append(p.journal, op)
+	p.saveJournalUnsafe()
+	p.Log.Infof("Server %s offline, %s of project %s deferred (op %s)", op.ServerID, op.Op, op.ProjectID, op.ID)
+
+	prj.Status = xaapiv1.StatusPending
+	prj.IsInSync = false
+	return prj
+}
+
+// _deferAdd Queue creation of a project (must be called with pjMutex locked)
+func (p *Projects) _deferAdd(newP xaapiv1.ProjectConfig, serverID, fromSid string) (*xaapiv1.ProjectConfig, error) {
+	if newP.ClientPath == "" {
+		return nil, fmt.Errorf("ClientPath must be set")
+	}
+	if _, err := p.newProjectObj(newP.Type, nil); err != nil {
+		return nil, err
+	}
+	if newP.ID == "" {
+		newP.ID = uuid.NewV1().String()
+	} else if _, exist := p.projects[newP.ID]; exist {
+		return nil, fmt.Errorf("Project %s already exists", newP.ID)
+	} else if _, exist := p.offline[newP.ID]; exist {
+		return nil, fmt.Errorf("Project %s already exists", newP.ID)
+	}
+	newP.ServerID = serverID
+
+	cached := newP
+	cached.Status = xaapiv1.StatusOffline
+	cached.IsInSync = false
+	p.offline[newP.ID] = cached
+	p.saveCacheUnsafe()
+
+	prj := p._pushOp(projectOp{
+		Op:        xaapiv1.ProjectOpAdd,
+		ServerID:  serverID,
+		ProjectID: newP.ID,
+		Config:    newP,
+		FromSid:   fromSid,
+	}, cached)
+	return &prj, nil
+}
+
+// _deferUpdate Queue update of a project (must be called with pjMutex locked)
+func (p *Projects) _deferUpdate(cur, prj xaapiv1.ProjectConfig, fromSid string) (*xaapiv1.ProjectConfig, error) {
+	newPrj, dirty, err := mergeUpdatableFields(cur, prj)
+	if err != nil || !dirty {
+		return &newPrj, err
+	}
+
+	// Cached projects show new values immediately
+	if _, exist := p.offline[cur.ID]; exist {
+		p.offline[cur.ID] = newPrj
+		p.saveCacheUnsafe()
+	}
+
+	res := p._pushOp(projectOp{
+		Op:        xaapiv1.ProjectOpUpdate,
+		ServerID:  cur.ServerID,
+		ProjectID: cur.ID,
+		Config:    prj,
+		FromSid:   fromSid,
+	}, newPrj)
+
+	if err := p.events.Emit(xaapiv1.EVTProjectChange, res, fromSid); err != nil {
+		p.Log.Warningf("Cannot notify project change: %v", err)
+	}
+	return &res, nil
+}
+
+// _deferDelete Queue deletion of a project (must be called with pjMutex locked)
+func (p *Projects) _deferDelete(cur xaapiv1.ProjectConfig, fromSid string) (xaapiv1.ProjectConfig, error) {
+	res := p._pushOp(projectOp{
+		Op:        xaapiv1.ProjectOpDelete,
+		ServerID:  cur.ServerID,
+		ProjectID: cur.ID,
+		FromSid:   fromSid,
+	}, cur)
+
+	if err := p.events.Emit(xaapiv1.EVTProjectChange, res, fromSid); err != nil {
+		p.Log.Warningf("Cannot notify project change: %v", err)
+	}
+	return res, nil
+}
+
+// replayJournal Replay in order operations deferred while svr was offline
+func (p *Projects) replayJournal(svr *XdsServer) {
+	pjMutex.Lock()
+	ops := []projectOp{}
+	for _, op := range p.journal {
+		if op.ServerID == svr.ID {
+			ops = append(ops, op)
+		}
+	}
+	// Cached copies are replaced by the projects really hold by server
+	cachedPrj := make(map[string]xaapiv1.ProjectConfig)
+	for _, op := range ops {
+		if prj, exist := p.offline[op.ProjectID]; exist {
+			cachedPrj[op.ProjectID] = prj
+			delete(p.offline, op.ProjectID)
+		}
+	}
+	pjMutex.Unlock()
+
+	if len(ops) == 0 {
+		return
+	}
+	p.Log.Infof("Replay %d pending project operations on server %s", len(ops), svr.ID)
+
+	for i, op := range ops {
+		res := p._replayOp(op)
+
+		// Following operations refer to project ID set by server
+		if op.Op == xaapiv1.ProjectOpAdd && res.Status == xaapiv1.ProjectOpDone && res.Project.ID != op.ProjectID {
+			delete(cachedPrj, op.ProjectID)
+			for j := i + 1; j < len(ops); j++ {
+				if ops[j].ProjectID == op.ProjectID {
+					ops[j].ProjectID = res.Project.ID
+				}
+			}
+		}
+
+		pjMutex.Lock()
+		for i, o := range p.journal {
+			if o.ID == op.ID {
+				p.journal = append(p.journal[:i], p.journal[i+1:]...)
+				break
+			}
+		}
+		p.saveJournalUnsafe()
+		pjMutex.Unlock()
+
+		if res.Status != xaapiv1.ProjectOpDone {
+			p.Log.Warningf("Pending %s of project %s %s: %s", op.Op, op.ProjectID, res.Status, res.Error)
+		}
+		if err := p.events.Emit(xaapiv1.EVTProjectOpDone, res, op.FromSid); err != nil {
+			p.Log.Warningf("Cannot notify project operation result: %v", err)
+		}
+	}
+
+	// Cached projects that don't exist on server (eg. deleted meanwhile or
+	// creation failure) are removed
+	pjMutex.Lock()
+	for id, prj := range cachedPrj {
+		if _, exist := p.projects[id]; exist {
+			continue
+		}
+		if err := p.events.Emit(xaapiv1.EVTProjectDelete, prj, ""); err != nil {
+			p.Log.Warningf("Cannot notify project deletion: %v", err)
+		}
+	}
+	p.saveCacheUnsafe()
+	pjMutex.Unlock()
+}
+
+// _replayOp Execute a deferred operation
+func (p *Projects) _replayOp(op projectOp) xaapiv1.ProjectOpResult {
+	res := xaapiv1.ProjectOpResult{
+		OpID:      op.ID,
+		Op:        op.Op,
+		ProjectID: op.ProjectID,
+		Status:    xaapiv1.ProjectOpDone,
+		Project:   op.Config,
+	}
+
+	pjMutex.Lock()
+	_, exist := p.projects[op.ProjectID]
+	pjMutex.Unlock()
+
+	var prj *xaapiv1.ProjectConfig
+	var err error
+	switch op.Op {
+	case xaapiv1.ProjectOpAdd:
+		if exist {
+			res.Status = xaapiv1.ProjectOpConflict
+			res.Error = "project already exists on server"
+			return res
+		}
+		if prj, err = p.createUpdate(op.Config, true, false); err == nil {
+			p.replayAdded(op, *prj)
+		}
+
+	case xaapiv1.ProjectOpUpdate:
+		if !exist {
+			res.Status = xaapiv1.ProjectOpConflict
+			res.Error = "project deleted on server side"
+			return res
+		}
+		prj, err = p.Update(op.ProjectID, op.Config, op.FromSid)
+
+	case xaapiv1.ProjectOpDelete:
+		if !exist {
+			res.Status = xaapiv1.ProjectOpConflict
+			res.Error = "project already deleted on server side"
+			return res
+		}
+		var delPrj xaapiv1.ProjectConfig
+		delPrj, err = p.Delete(op.ProjectID, op.FromSid)
+		prj = &delPrj
+
+	default:
+		err = fmt.Errorf("unknown operation %s", op.Op)
+	}
+
+	if err != nil {
+		res.Status = xaapiv1.ProjectOpFailed
+		res.Error = err.Error()
+	} else if prj != nil {
+		res.Project = *prj
+	}
+	return res
+}
+
+// replayAdded Update settings files, journal and clients of a project created
+// by replay of a deferred add. Project has already been notified to clients
+// when add was deferred, so ID set by server (when it differs from the
+// temporary one) replaces the temporary ID.
+func (p *Projects) replayAdded(op projectOp, prj xaapiv1.ProjectConfig) {
+	pjMutex.Lock()
+	defer pjMutex.Unlock()
+
+	renamed := prj.ID != op.ProjectID
+	if renamed {
+		p.Log.Infof("Project %s created with ID %s on server %s", op.ProjectID, prj.ID, op.ServerID)
+
+		p._removeFiles(op.Config)
+		if pf, exist := p.files[op.ProjectID]; exist {
+			delete(p.files, op.ProjectID)
+			p.files[prj.ID] = pf
+			p.saveFilesStateUnsafe()
+		}
+		for i := range p.journal {
+			if p.journal[i].ProjectID == op.ProjectID {
+				p.journal[i].ProjectID = prj.ID
+			}
+		}
+		p.saveJournalUnsafe()
+	}
+
+	if err := p._writeFiles(prj); err != nil {
+		p.Log.Warningf("Cannot update settings files of project %s: %v", prj.ID, err)
+	}
+	prj = p._withFiles(prj)
+
+	if !renamed {
+		if err := p.events.Emit(xaapiv1.EVTProjectChange, prj, op.FromSid); err != nil {
+			p.Log.Warningf("Cannot notify project change: %v", err)
+		}
+		return
+	}
+	if err := p.events.Emit(xaapiv1.EVTProjectDelete, op.Config, op.FromSid); err != nil {
+		p.Log.Warningf("Cannot notify project deletion: %v", err)
+	}
+	if err := p.events.Emit(xaapiv1.EVTProjectAdd, prj, op.FromSid); err != nil {
+		p.Log.Warningf("Cannot notify project add: %v", err)
+	}
+}
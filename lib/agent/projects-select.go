@@ -0,0 +1,129 @@
+/*
+ * Copyright (C) 2017 "IoT.bzh"
+ * Author Sebastien Douheret <sebastien@iot.bzh>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package agent
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/iotbzh/xds-agent/lib/xaapiv1"
+)
+
+// ServerSelector Policy used to choose a XDS Server when project ServerID is
+// set to xaapiv1.ServerIDAuto
+type ServerSelector interface {
+	// Select returns the best server among candidates, candidates are
+	// connected and are able to host the project
+	Select(candidates []*XdsServer, prj xaapiv1.ProjectConfig) (*XdsServer, error)
+}
+
+// LeastLoadedSelector Select the server that runs the fewest commands
+type LeastLoadedSelector struct{}
+
+// Select implements ServerSelector interface
+func (l *LeastLoadedSelector) Select(candidates []*XdsServer, prj xaapiv1.ProjectConfig) (*XdsServer, error) {
+	if len(candidates) == 0 {
+		return nil, fmt.Errorf("no candidate server")
+	}
+	best := candidates[0]
+	for _, svr := range candidates[1:] {
+		if svr.CommandCount() < best.CommandCount() {
+			best = svr
+		}
+	}
+	return best, nil
+}
+
+// FirstSelector Select the first candidate (servers sorted by ID)
+type FirstSelector struct{}
+
+// Select implements ServerSelector interface
+func (f *FirstSelector) Select(candidates []*XdsServer, prj xaapiv1.ProjectConfig) (*XdsServer, error) {
+	if len(candidates) == 0 {
+		return nil, fmt.Errorf("no candidate server")
+	}
+	return candidates[0], nil
+}
+
+// NewServerSelector returns the selection policy set in projects config
+// (leastLoaded policy is returned with an error when name is unknown)
+func NewServerSelector(name string) (ServerSelector, error) {
+	switch name {
+	case "", "leastLoaded":
+		return &LeastLoadedSelector{}, nil
+	case "first":
+		return &FirstSelector{}, nil
+	}
+	return &LeastLoadedSelector{}, fmt.Errorf("Unknown server selection policy %s", name)
+}
+
+// SetServerSelector Change the policy used to select server of new projects
+func (p *Projects) SetServerSelector(sel ServerSelector) {
+	if sel == nil {
+		sel = &LeastLoadedSelector{}
+	}
+	p.selector = sel
+}
+
+// selectServer Find a server able to host a project and select the best one
+// using selection policy (SDKs lists are requested to servers, so must be
+// called without pjMutex locked)
+func (p *Projects) selectServer(prj xaapiv1.ProjectConfig) (*XdsServer, error) {
+	// Sort servers by ID to get a stable selection
+	ids := []string{}
+	for id := range p.xdsServers {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	candidates := []*XdsServer{}
+	for _, id := range ids {
+		svr := p.xdsServers[id]
+		if svr.Disabled || !svr.Connected || svr.ServerConfig == nil {
+			continue
+		}
+		if b, exist := svr.ServerConfig.SupportedSharing[string(prj.Type)]; !exist || !b {
+			continue
+		}
+		if prj.DefaultSdk != "" {
+			found, err := svr.HasSdk(prj.DefaultSdk)
+			if err != nil {
+				p.Log.Warningf("Cannot get SDKs list of server %s: %v", svr.ID, err)
+				continue
+			}
+			if !found {
+				continue
+			}
+		}
+		candidates = append(candidates, svr)
+	}
+
+	if len(candidates) == 0 {
+		if prj.DefaultSdk != "" {
+			return nil, fmt.Errorf("No connected server supports project type %s with SDK %s", prj.Type, prj.DefaultSdk)
+		}
+		return nil, fmt.Errorf("No connected server supports project type %s", prj.Type)
+	}
+
+	svr, err := p.selector.Select(candidates, prj)
+	if err != nil {
+		return nil, err
+	}
+	p.Log.Infof("Server %s automatically selected for project %s", svr.ID, prj.ClientPath)
+	return svr, nil
+}
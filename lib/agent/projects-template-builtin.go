@@ -0,0 +1,127 @@
+/*
+ * Copyright (C) 2017 "IoT.bzh"
+ * Author Sebastien Douheret <sebastien@iot.bzh>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package agent
+
+import "github.com/iotbzh/xds-agent/lib/xaapiv1"
+
+// Parameters shared by built-in templates
+var tmplParamName = xaapiv1.TemplateParam{
+	Name:        "NAME",
+	Description: "Name of application (used as binary and package name)",
+	Default:     "helloworld",
+	Required:    true,
+}
+
+var tmplParamVersion = xaapiv1.TemplateParam{
+	Name:        "VERSION",
+	Description: "Version of application",
+	Default:     "1.0",
+	Required:    true,
+}
+
+// Source file shared by built-in templates
+const tmplMainC = `#include <stdio.h>
+
+int main(int argc, char *argv[])
+{
+	printf("Hello from {{.NAME}} {{.VERSION}}\n");
+	return 0;
+}
+`
+
+// builtinTemplates Templates always available
+var builtinTemplates = []projectTemplate{
+	{
+		ProjectTemplate: xaapiv1.ProjectTemplate{
+			Name:        "cmake",
+			Description: "C application built using CMake",
+			Params:      []xaapiv1.TemplateParam{tmplParamName, tmplParamVersion},
+		},
+		files: []templateFile{
+			{Path: "CMakeLists.txt", Content: `cmake_minimum_required(VERSION 3.3)
+project({{.NAME}} VERSION {{.VERSION}} LANGUAGES C)
+
+add_executable({{.NAME}} src/main.c)
+install(TARGETS {{.NAME}} DESTINATION bin)
+`},
+			{Path: "src/main.c", Content: tmplMainC},
+		},
+	},
+	{
+		ProjectTemplate: xaapiv1.ProjectTemplate{
+			Name:        "autotools",
+			Description: "C application built using autoconf and automake",
+			Params:      []xaapiv1.TemplateParam{tmplParamName, tmplParamVersion},
+		},
+		files: []templateFile{
+			{Path: "configure.ac", Content: `AC_INIT([{{.NAME}}], [{{.VERSION}}])
+AM_INIT_AUTOMAKE([foreign subdir-objects])
+AC_PROG_CC
+AC_CONFIG_FILES([Makefile])
+AC_OUTPUT
+`},
+			{Path: "Makefile.am", Content: `bin_PROGRAMS = {{.NAME}}
+{{.NAME}}_SOURCES = src/main.c
+`},
+			{Path: "autogen.sh", Mode: 0755, Content: `#!/bin/sh
+autoreconf --install --force
+`},
+			{Path: "src/main.c", Content: tmplMainC},
+		},
+	},
+	{
+		ProjectTemplate: xaapiv1.ProjectTemplate{
+			Name:        "agl-app",
+			Description: "AGL application (widget) built using CMake",
+			Params: []xaapiv1.TemplateParam{tmplParamName, tmplParamVersion,
+				{
+					Name:        "DESCRIPTION",
+					Description: "Short description of application",
+					Default:     "AGL application",
+				},
+			},
+		},
+		files: []templateFile{
+			{Path: "CMakeLists.txt", Content: `cmake_minimum_required(VERSION 3.3)
+project({{.NAME}} VERSION {{.VERSION}} LANGUAGES C)
+
+include(GNUInstallDirs)
+
+add_executable({{.NAME}} src/main.c)
+
+# Widget content
+set(WIDGET_DIR ${CMAKE_BINARY_DIR}/package)
+add_custom_target(widget ALL
+	COMMAND ${CMAKE_COMMAND} -E make_directory ${WIDGET_DIR}/bin
+	COMMAND ${CMAKE_COMMAND} -E copy $<TARGET_FILE:{{.NAME}}> ${WIDGET_DIR}/bin/
+	COMMAND ${CMAKE_COMMAND} -E copy ${CMAKE_SOURCE_DIR}/conf.d/wgt/config.xml ${WIDGET_DIR}/
+	COMMAND wgtpkg-pack -f -o ${CMAKE_BINARY_DIR}/{{.NAME}}.wgt ${WIDGET_DIR}
+	DEPENDS {{.NAME}})
+`},
+			{Path: "conf.d/wgt/config.xml", Content: `<?xml version="1.0" encoding="UTF-8"?>
+<widget xmlns="http://www.w3.org/ns/widgets" id="{{.NAME}}" version="{{.VERSION}}">
+  <name>{{.NAME}}</name>
+  <description>{{.DESCRIPTION}}</description>
+  <content src="bin/{{.NAME}}" type="application/vnd.agl.native"/>
+  <license>APL2.0</license>
+</widget>
+`},
+			{Path: "src/main.c", Content: tmplMainC},
+		},
+	},
+}
@@ -0,0 +1,271 @@
+/*
+ * Copyright (C) 2017 "IoT.bzh"
+ * Author Sebastien Douheret <sebastien@iot.bzh>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package agent
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/iotbzh/xds-agent/lib/xaapiv1"
+	common "github.com/iotbzh/xds-common/golib"
+)
+
+// Project templates
+//
+// A template is a set of files (names and contents are text/template
+// templates, eg. {{.APP_NAME}}) created in ClientPath of a new project.
+// Built-in templates are defined in projects-template-builtin.go, user
+// templates are directories of templatesDir (see agent config) with layout:
+//   <templatesDir>/<name>/template.json   name, description and params
+//   <templatesDir>/<name>/files/...       files of template
+// A user template overwrites a built-in template with the same name.
+
+// templateManifestFile Description file of a user template
+const templateManifestFile = "template.json"
+
+// projectTemplate Template definition and files
+type projectTemplate struct {
+	xaapiv1.ProjectTemplate
+	files []templateFile // built-in templates only
+	dir   string         // user templates only
+}
+
+// templateFile File of a built-in template
+type templateFile struct {
+	Path    string
+	Content string
+	Mode    os.FileMode
+}
+
+// GetTemplates returns all available templates sorted by name
+func (p *Projects) GetTemplates() []xaapiv1.ProjectTemplate {
+	tmpls := p.loadTemplates()
+	names := []string{}
+	for name := range tmpls {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	res := []xaapiv1.ProjectTemplate{}
+	for _, name := range names {
+		res = append(res, tmpls[name].ProjectTemplate)
+	}
+	return res
+}
+
+// AddFromTemplate creates files of project from a template and then adds project
+func (p *Projects) AddFromTemplate(args xaapiv1.ProjectAddArgs, fromSid string) (*xaapiv1.ProjectConfig, error) {
+	if args.Template == "" {
+		return p.Add(args.ProjectConfig, fromSid)
+	}
+	if args.ClientPath == "" {
+		return nil, fmt.Errorf("ClientPath must be set")
+	}
+	clientPath, err := confineClientPath(p.Config.FileConf.ProjectsConf.ClientRoot, args.ClientPath)
+	if err != nil {
+		return nil, err
+	}
+	args.ClientPath = clientPath
+
+	tmpl, exist := p.loadTemplates()[args.Template]
+	if !exist {
+		return nil, fmt.Errorf("Unknown template %s", args.Template)
+	}
+	undo, err := tmpl.scaffold(args.ClientPath, args.Params)
+	if err != nil {
+		return nil, err
+	}
+	p.Log.Infof("Project files created in %s from template %s", args.ClientPath, args.Template)
+
+	prj, err := p.Add(args.ProjectConfig, fromSid)
+	if err != nil {
+		p.Log.Infof("Remove files created from template %s in %s", args.Template, args.ClientPath)
+		undo()
+	}
+	return prj, err
+}
+
+// loadTemplates returns built-in and user templates indexed by name
+func (p *Projects) loadTemplates() map[string]*projectTemplate {
+	tmpls := make(map[string]*projectTemplate)
+	for _, t := range builtinTemplates {
+		tt := t
+		tt.BuiltIn = true
+		tmpls[t.Name] = &tt
+	}
+
+	dir := p.Config.FileConf.ProjectsConf.TemplatesDir
+	if dir == "" || !common.Exists(dir) {
+		return tmpls
+	}
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		p.Log.Warningf("Cannot read templates directory: %v", err)
+		return tmpls
+	}
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		tDir := filepath.Join(dir, e.Name())
+		data, err := ioutil.ReadFile(filepath.Join(tDir, templateManifestFile))
+		if err != nil {
+			continue
+		}
+		t := projectTemplate{dir: tDir}
+		if err := json.Unmarshal(data, &t.ProjectTemplate); err != nil {
+			p.Log.Warningf("Invalid template %s: %v", tDir, err)
+			continue
+		}
+		if t.Name == "" {
+			t.Name = e.Name()
+		}
+		t.BuiltIn = false
+		tmpls[t.Name] = &t
+	}
+	return tmpls
+}
+
+// params returns values of all template parameters (default values are used
+// for missing parameters)
+func (t *projectTemplate) params(values map[string]string) (map[string]string, error) {
+	res := make(map[string]string)
+	for _, prm := range t.Params {
+		v, exist := values[prm.Name]
+		if !exist || v == "" {
+			v = prm.Default
+		}
+		if v == "" && prm.Required {
+			return nil, fmt.Errorf("Missing parameter %s of template %s", prm.Name, t.Name)
+		}
+		res[prm.Name] = v
+	}
+	return res, nil
+}
+
+// scaffold Create files of template in dir (existing files are never
+// overwritten), returned function removes created files and directories
+func (t *projectTemplate) scaffold(dir string, values map[string]string) (func(), error) {
+	params, err := t.params(values)
+	if err != nil {
+		return nil, err
+	}
+
+	files := t.files
+	if t.dir != "" {
+		if files, err = readTemplateFiles(filepath.Join(t.dir, "files")); err != nil {
+			return nil, fmt.Errorf("Cannot read files of template %s: %v", t.Name, err)
+		}
+	}
+
+	// Render all files first to not create a partial project on error
+	type outFile struct {
+		path string
+		data []byte
+		mode os.FileMode
+	}
+	outs := []outFile{}
+	for _, f := range files {
+		name, err := renderTemplate(f.Path, params)
+		if err != nil {
+			return nil, fmt.Errorf("Template %s, invalid file name %s: %v", t.Name, f.Path, err)
+		}
+		path := filepath.Join(dir, filepath.Clean("/"+name))
+		if common.Exists(path) {
+			return nil, fmt.Errorf("File %s already exists", path)
+		}
+		data, err := renderTemplate(f.Content, params)
+		if err != nil {
+			return nil, fmt.Errorf("Template %s, invalid file %s: %v", t.Name, f.Path, err)
+		}
+		mode := f.Mode
+		if mode == 0 {
+			mode = 0644
+		}
+		outs = append(outs, outFile{path: path, data: []byte(data), mode: mode})
+	}
+
+	// Created files and directories, in creation order
+	created := []string{}
+	undo := func() {
+		for i := len(created) - 1; i >= 0; i-- {
+			os.Remove(created[i])
+		}
+	}
+	for _, o := range outs {
+		missing := []string{}
+		for d := filepath.Dir(o.path); !common.Exists(d); d = filepath.Dir(d) {
+			missing = append([]string{d}, missing...)
+		}
+		if err := os.MkdirAll(filepath.Dir(o.path), 0755); err != nil {
+			undo()
+			return nil, err
+		}
+		created = append(created, missing...)
+		if err := ioutil.WriteFile(o.path, o.data, o.mode); err != nil {
+			undo()
+			return nil, fmt.Errorf("Cannot create %s: %v", o.path, err)
+		}
+		created = append(created, o.path)
+	}
+	return undo, nil
+}
+
+// readTemplateFiles returns files of a user template directory
+func readTemplateFiles(root string) ([]templateFile, error) {
+	files := []templateFile{}
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		files = append(files, templateFile{Path: rel, Content: string(data), Mode: info.Mode().Perm()})
+		return nil
+	})
+	return files, err
+}
+
+// renderTemplate Execute text template s with params
+func renderTemplate(s string, params map[string]string) (string, error) {
+	if !strings.Contains(s, "{{") {
+		return s, nil
+	}
+	tmpl, err := template.New("").Option("missingkey=error").Parse(s)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, params); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
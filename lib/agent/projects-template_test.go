@@ -0,0 +1,53 @@
+/*
+ * Copyright (C) 2017 "IoT.bzh"
+ * Author Sebastien Douheret <sebastien@iot.bzh>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package agent
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/iotbzh/xds-agent/lib/xaapiv1"
+)
+
+func TestTemplateClientPathConfined(t *testing.T) {
+	ctx, cleanup := newTestContext(t)
+	defer cleanup()
+
+	base, err := ioutil.TempDir("", "xds-agent-template")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(base)
+	root := filepath.Join(base, "root")
+	outside := filepath.Join(base, "outside")
+	ctx.Config.FileConf.ProjectsConf.ClientRoot = root
+
+	for _, path := range []string{outside, "../outside", "prj/../../outside"} {
+		args := xaapiv1.ProjectAddArgs{
+			ProjectConfig: xaapiv1.ProjectConfig{Label: "prj", ClientPath: path},
+			Template:      "cmake",
+		}
+		if _, err := ctx.projects.AddFromTemplate(args, ""); err == nil {
+			t.Errorf("client path %s must be rejected", path)
+		}
+	}
+	if _, err := os.Stat(outside); err == nil {
+		t.Errorf("files must not be created outside of client root")
+	}
+}
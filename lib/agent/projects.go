@@ -20,15 +20,12 @@ package agent
 import (
 	"fmt"
 	"log"
-	"os"
-	"path/filepath"
 	"strings"
 	"time"
 
 	"github.com/franciscocpg/reflectme"
 	"github.com/iotbzh/xds-agent/lib/syncthing"
 	"github.com/iotbzh/xds-agent/lib/xaapiv1"
-	common "github.com/iotbzh/xds-common/golib"
 	"github.com/iotbzh/xds-server/lib/xsapiv1"
 	"github.com/syncthing/syncthing/lib/sync"
 )
@@ -38,6 +35,11 @@ type Projects struct {
 	*Context
 	SThg     *st.SyncThing
 	projects map[string]*IPROJECT
+	offline  map[string]xaapiv1.ProjectConfig // cached projects of unreachable servers
+	journal  []projectOp                      // operations deferred while servers are offline
+	files    map[string]*projectFiles         // settings files managed in ClientPath of projects
+	busy     map[string]bool                  // projects being migrated
+	selector ServerSelector
 }
 
 // Mutex to make add/delete atomic
@@ -45,11 +47,29 @@ var pjMutex = sync.NewMutex()
 
 // NewProjects Create a new instance of Project Model
 func NewProjects(ctx *Context, st *st.SyncThing) *Projects {
-	return &Projects{
+	p := &Projects{
 		Context:  ctx,
 		SThg:     st,
 		projects: make(map[string]*IPROJECT),
+		offline:  make(map[string]xaapiv1.ProjectConfig),
+		files:    make(map[string]*projectFiles),
+		busy:     make(map[string]bool),
 	}
+	sel, err := NewServerSelector(ctx.Config.FileConf.ProjectsConf.ServerSelection)
+	if err != nil {
+		ctx.Log.Warningf("%v, use leastLoaded policy", err)
+	}
+	p.SetServerSelector(sel)
+	if err := p.loadCache(); err != nil {
+		ctx.Log.Warningf("Cannot load projects cache: %v", err)
+	}
+	if err := p.loadJournal(); err != nil {
+		ctx.Log.Warningf("Cannot load projects journal: %v", err)
+	}
+	if err := p.loadFilesState(); err != nil {
+		ctx.Log.Warningf("Cannot load projects files state: %v", err)
+	}
+	return p
 }
 
 // Init Load Projects configuration
@@ -72,6 +92,22 @@ func (p *Projects) Init(server *XdsServer) error {
 			errMsg += fmt.Sprintf("Cannot retrieve folders config of XDS server ID %s : %v \n", svr.ID, err.Error())
 			continue
 		}
+
+		// Projects already known for this server (cached or loaded before)
+		known := make(map[string]bool)
+		pjMutex.Lock()
+		for id, prj := range p.offline {
+			if prj.ServerID == svr.ID {
+				known[id] = true
+			}
+		}
+		for id, fc := range p.projects {
+			if (*fc).GetServer() == svr {
+				known[id] = true
+			}
+		}
+		pjMutex.Unlock()
+
 		p.Log.Debugf("Connected to XDS Server %s, %d projects detected", svr.ID, len(xFlds))
 		for _, prj := range xFlds {
 			newP := svr.FolderToProject(prj)
@@ -85,6 +121,26 @@ func (p *Projects) Init(server *XdsServer) error {
 				continue
 			}
 		}
+
+		pjMutex.Lock()
+		p._reconcileCache(svr, known)
+
+		// Settings files may be outdated (eg. default SDK changed meanwhile),
+		// projects created before settings files were managed use default
+		// formats
+		for _, fc := range p.projects {
+			if (*fc).GetServer() != svr {
+				continue
+			}
+			p._filesOf((*fc).GetProject().ID, nil, "")
+			if err := p._writeFiles(*(*fc).GetProject()); err != nil {
+				p.Log.Warningf("Cannot update settings files of project %s: %v", (*fc).GetProject().ID, err)
+			}
+		}
+		p.saveFilesStateUnsafe()
+		pjMutex.Unlock()
+
+		p.replayJournal(svr)
 	}
 
 	p.Log.Infof("Number of loaded Projects: %d", len(p.projects))
@@ -107,6 +163,11 @@ func (p *Projects) ResolveID(id string) (string, error) {
 			match = append(match, iid)
 		}
 	}
+	for iid := range p.offline {
+		if strings.HasPrefix(iid, id) {
+			match = append(match, iid)
+		}
+	}
 
 	if len(match) == 1 {
 		return match[0], nil
@@ -128,6 +189,23 @@ func (p *Projects) Get(id string) *IPROJECT {
 	return fc
 }
 
+// GetConfig returns public config of a project (including offline ones)
+func (p *Projects) GetConfig(id string) (xaapiv1.ProjectConfig, bool) {
+	pjMutex.Lock()
+	defer pjMutex.Unlock()
+
+	prj := xaapiv1.ProjectConfig{}
+	if fc, exist := p.projects[id]; exist {
+		prj = projectPublicConfig(fc)
+	} else if prj, exist = p.offline[id]; !exist {
+		return prj, false
+	}
+	if p._hasPendingOp(id) {
+		prj.Status = xaapiv1.StatusPending
+	}
+	return p._withFiles(prj), true
+}
+
 // GetProjectArr returns the config of all folders as an array
 func (p *Projects) GetProjectArr() []xaapiv1.ProjectConfig {
 	pjMutex.Lock()
@@ -140,35 +218,55 @@ func (p *Projects) GetProjectArr() []xaapiv1.ProjectConfig {
 func (p *Projects) GetProjectArrUnsafe() []xaapiv1.ProjectConfig {
 	conf := []xaapiv1.ProjectConfig{}
 	for _, v := range p.projects {
-		prj := (*v).GetProject()
-		conf = append(conf, *prj)
+		conf = append(conf, projectPublicConfig(v))
+	}
+	for _, prj := range p.offline {
+		conf = append(conf, prj)
+	}
+	for i := range conf {
+		if p._hasPendingOp(conf[i].ID) {
+			conf[i].Status = xaapiv1.StatusPending
+		}
+		conf[i] = p._withFiles(conf[i])
 	}
 	return conf
 }
 
 // Add adds a new folder
-func (p *Projects) Add(newP xaapiv1.ProjectConfig, fromSid, requestURL string) (*xaapiv1.ProjectConfig, error) {
-	prj, err := p.createUpdate(newP, true, false)
+func (p *Projects) Add(newP xaapiv1.ProjectConfig, fromSid string) (*xaapiv1.ProjectConfig, error) {
+	var prj *xaapiv1.ProjectConfig
+	var err error
+
+	if err := checkFileFormats(newP.ConfFiles); err != nil {
+		return nil, err
+	}
+
+	deferred := false
+	if p.deferEnabled() && newP.ServerID != xaapiv1.ServerIDAuto {
+		pjMutex.Lock()
+		if sid, offline := p._offlineServer(newP.ServerID); offline {
+			prj, err = p._deferAdd(newP, sid, fromSid)
+			deferred = true
+		}
+		pjMutex.Unlock()
+	}
+	if !deferred {
+		prj, err = p.createUpdate(newP, true, false)
+	}
 	if err != nil {
 		return prj, err
 	}
 
-	// Create xds-project.conf file
-	prjConfFile := filepath.Join(prj.ClientPath, "xds-project.conf")
-	if !common.Exists(prjConfFile) {
-		fd, err := os.OpenFile(prjConfFile, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0666)
-		if err != nil {
-			return prj, fmt.Errorf("Cannot create xds-project.conf: %v", err.Error())
-		}
-		fd.WriteString("# XDS project settings\n")
-		fd.WriteString("export XDS_AGENT_URL=" + requestURL + "\n")
-		fd.WriteString("export XDS_PROJECT_ID=" + prj.ID + "\n")
-		if prj.DefaultSdk != "" {
-			fd.WriteString("export XDS_SDK_ID=" + prj.DefaultSdk + "\n")
-		} else {
-			fd.WriteString("#export XDS_SDK_ID=???\n")
-		}
-		fd.Close()
+	// Create settings files (xds-project.conf, ...)
+	pjMutex.Lock()
+	p._filesOf(prj.ID, newP.ConfFiles, "")
+	p.saveFilesStateUnsafe()
+	err = p._writeFiles(*prj)
+	res := p._withFiles(*prj)
+	prj = &res
+	pjMutex.Unlock()
+	if err != nil {
+		return prj, err
 	}
 
 	// Notify client with event
@@ -183,6 +281,15 @@ func (p *Projects) Add(newP xaapiv1.ProjectConfig, fromSid, requestURL string) (
 func (p *Projects) createUpdate(newF xaapiv1.ProjectConfig, create bool, initial bool) (*xaapiv1.ProjectConfig, error) {
 	var err error
 
+	// Server selection requests servers, so don't hold lock
+	if newF.ServerID == xaapiv1.ServerIDAuto {
+		sel, err := p.selectServer(newF)
+		if err != nil {
+			return nil, err
+		}
+		newF.ServerID = sel.ID
+	}
+
 	pjMutex.Lock()
 	defer pjMutex.Unlock()
 
@@ -198,9 +305,11 @@ func (p *Projects) createUpdate(newF xaapiv1.ProjectConfig, create bool, initial
 	}
 	var svr *XdsServer
 	var exist bool
-	if svr, exist = p.xdsServers[newF.ServerID]; !exist {
+	if svr, exist = p.getXdsServer(newF.ServerID); !exist {
 		return nil, fmt.Errorf("Unknown Server ID %s", newF.ServerID)
 	}
+	// ServerID may be a server name, always store real ID
+	newF.ServerID = svr.ID
 
 	// Check type supported
 	b, exist := svr.ServerConfig.SupportedSharing[string(newF.Type)]
@@ -209,21 +318,9 @@ func (p *Projects) createUpdate(newF xaapiv1.ProjectConfig, create bool, initial
 	}
 
 	// Create a new folder object
-	var fld IPROJECT
-	switch newF.Type {
-	// SYNCTHING
-	case xaapiv1.TypeCloudSync:
-		if p.SThg != nil {
-			fld = NewProjectST(p.Context, svr)
-		} else {
-			return nil, fmt.Errorf("Cloud Sync project not supported")
-		}
-
-	// PATH MAP
-	case xaapiv1.TypePathMap:
-		fld = NewProjectPathMap(p.Context, svr)
-	default:
-		return nil, fmt.Errorf("Unsupported folder type")
+	fld, err := p.newProjectObj(newF.Type, svr)
+	if err != nil {
+		return nil, err
 	}
 
 	var newPrj *xaapiv1.ProjectConfig
@@ -251,6 +348,9 @@ func (p *Projects) createUpdate(newF xaapiv1.ProjectConfig, create bool, initial
 
 	// Add to folders list
 	p.projects[newPrj.ID] = &fld
+	if !initial {
+		p.saveCacheUnsafe()
+	}
 
 	// Force sync to get an initial sync status
 	// (need to defer to be sure that WS events will arrive after HTTP creation reply)
@@ -262,6 +362,23 @@ func (p *Projects) createUpdate(newF xaapiv1.ProjectConfig, create bool, initial
 	return newPrj, nil
 }
 
+// newProjectObj Create a new project object of the given type hold by svr
+func (p *Projects) newProjectObj(pType xaapiv1.ProjectType, svr *XdsServer) (IPROJECT, error) {
+	switch pType {
+	// SYNCTHING
+	case xaapiv1.TypeCloudSync:
+		if p.SThg != nil {
+			return NewProjectST(p.Context, svr), nil
+		}
+		return nil, fmt.Errorf("Cloud Sync project not supported")
+
+	// PATH MAP
+	case xaapiv1.TypePathMap:
+		return NewProjectPathMap(p.Context, svr), nil
+	}
+	return nil, fmt.Errorf("Unsupported folder type")
+}
+
 // Delete deletes a specific folder
 func (p *Projects) Delete(id, fromSid string) (xaapiv1.ProjectConfig, error) {
 	var err error
@@ -272,16 +389,34 @@ func (p *Projects) Delete(id, fromSid string) (xaapiv1.ProjectConfig, error) {
 	fld := xaapiv1.ProjectConfig{}
 	fc, exist := p.projects[id]
 	if !exist {
+		if prj, exist := p.offline[id]; exist {
+			if _, known := p.getXdsServer(prj.ServerID); !known {
+				// Server has been removed, project only remains in cache
+				return p._deleteCached(prj, fromSid), nil
+			}
+			if p.deferEnabled() {
+				return p._deferDelete(prj, fromSid)
+			}
+			return prj, fmt.Errorf("XDS Server %s of project is offline", prj.ServerID)
+		}
 		return fld, fmt.Errorf("Unknown id")
 	}
 
 	prj := (*fc).GetProject()
+	if p.busy[id] {
+		return *prj, fmt.Errorf("Project is being migrated")
+	}
+	if svr := (*fc).GetServer(); p.deferEnabled() && (svr.Disabled || !svr.Connected) {
+		return p._deferDelete(*prj, fromSid)
+	}
 
 	if err = (*fc).Delete(); err != nil {
 		return *prj, err
 	}
 
 	delete(p.projects, id)
+	p.saveCacheUnsafe()
+	p._removeFiles(*prj)
 
 	// Notify client with event
 	if err := p.events.Emit(xaapiv1.EVTProjectDelete, *prj, fromSid); err != nil {
@@ -317,12 +452,97 @@ func (p *Projects) Update(id string, prj xaapiv1.ProjectConfig, fromSid string)
 
 	fc, exist := p.projects[id]
 	if !exist {
+		if cur, exist := p.offline[id]; exist {
+			if p.deferEnabled() {
+				return p._deferUpdate(cur, prj, fromSid)
+			}
+			return nil, fmt.Errorf("XDS Server %s of project is offline", cur.ServerID)
+		}
+		return nil, fmt.Errorf("Unknown id")
+	}
+	if p.busy[id] {
+		return nil, fmt.Errorf("Project is being migrated")
+	}
+	if svr := (*fc).GetServer(); p.deferEnabled() && (svr.Disabled || !svr.Connected) {
+		return p._deferUpdate(*(*fc).GetProject(), prj, fromSid)
+	}
+
+	newFld, dirty, err := mergeUpdatableFields(*(*fc).GetProject(), prj)
+	if err != nil {
+		return nil, err
+	}
+	if !dirty {
+		return &newFld, nil
+	}
+
+	upPrj, err := (*fc).Update(newFld)
+	if err != nil {
+		return nil, err
+	}
+	p.saveCacheUnsafe()
+	if err := p._writeFiles(*upPrj); err != nil {
+		p.Log.Warningf("Cannot update settings files of project %s: %v", id, err)
+	}
+
+	// Notify client with event
+	if err := p.events.Emit(xaapiv1.EVTProjectChange, *upPrj, fromSid); err != nil {
+		p.Log.Warningf("Cannot notify project change: %v", err)
+	}
+	return upPrj, err
+}
+
+// Relink Change client path of a project (project directory moved on
+// client side), project is kept on server side
+func (p *Projects) Relink(id, clientPath, fromSid string) (*xaapiv1.ProjectConfig, error) {
+
+	pjMutex.Lock()
+	defer pjMutex.Unlock()
+
+	fc, exist := p.projects[id]
+	if !exist {
+		if cur, exist := p.offline[id]; exist {
+			return nil, fmt.Errorf("XDS Server %s of project is offline", cur.ServerID)
+		}
 		return nil, fmt.Errorf("Unknown id")
 	}
+	if p.busy[id] {
+		return nil, fmt.Errorf("Project is being migrated")
+	}
+	if svr := (*fc).GetServer(); svr.Disabled || !svr.Connected {
+		return nil, fmt.Errorf("XDS Server %s of project is offline", svr.ID)
+	}
+
+	cur := *(*fc).GetProject()
+	if cur.ClientPath == clientPath {
+		return &cur, nil
+	}
+	newFld := cur
+	newFld.ClientPath = clientPath
+	upPrj, err := (*fc).Update(newFld)
+	if err != nil {
+		return nil, err
+	}
+	if upPrj.ClientPath != clientPath {
+		return nil, fmt.Errorf("XDS Server %s doesn't support client path change", upPrj.ServerID)
+	}
+	p.saveCacheUnsafe()
+	if err := p._writeFiles(*upPrj); err != nil {
+		p.Log.Warningf("Cannot update settings files of project %s: %v", id, err)
+	}
 
+	// Notify client with event
+	if err := p.events.Emit(xaapiv1.EVTProjectChange, *upPrj, fromSid); err != nil {
+		p.Log.Warningf("Cannot notify project change: %v", err)
+	}
+	return upPrj, nil
+}
+
+// mergeUpdatableFields Return a copy of cur where updatable fields are set
+// from prj (dirty is false when nothing changed)
+func mergeUpdatableFields(cur, prj xaapiv1.ProjectConfig) (xaapiv1.ProjectConfig, bool, error) {
 	// Copy current in a new object to change nothing in case of an error rises
 	newFld := xaapiv1.ProjectConfig{}
-	reflectme.Copy((*fc).GetProject(), &newFld)
+	reflectme.Copy(&cur, &newFld)
 
 	// Only update some fields
 	dirty := false
@@ -333,25 +553,11 @@ func (p *Projects) Update(id string, prj xaapiv1.ProjectConfig, fromSid string)
 			if err == nil && valNew != valCur {
 				err = reflectme.SetField(&newFld, fieldName, valNew)
 				if err != nil {
-					return nil, err
+					return newFld, false, err
 				}
 				dirty = true
 			}
 		}
 	}
-
-	if !dirty {
-		return &newFld, nil
-	}
-
-	upPrj, err := (*fc).Update(newFld)
-	if err != nil {
-		return nil, err
-	}
-
-	// Notify client with event
-	if err := p.events.Emit(xaapiv1.EVTProjectChange, *upPrj, fromSid); err != nil {
-		p.Log.Warningf("Cannot notify project change: %v", err)
-	}
-	return upPrj, err
+	return newFld, dirty, nil
 }
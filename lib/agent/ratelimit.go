@@ -0,0 +1,235 @@
+/*
+ * Copyright (C) 2017 "IoT.bzh"
+ * Author Sebastien Douheret <sebastien@iot.bzh>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package agent
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/googollee/go-socket.io"
+	"github.com/iotbzh/xds-agent/lib/xdsconfig"
+	"github.com/syncthing/syncthing/lib/sync"
+)
+
+const rateLimitCleanupPeriod = time.Minute
+const rateLimitIdleTime = 5 * time.Minute
+
+// tokenBucket Token bucket used to limit rate of one client
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// rateLimiter Limit rate of requests per key (eg. IP or session ID)
+type rateLimiter struct {
+	rate        float64 // tokens added per second
+	burst       float64 // bucket size
+	buckets     map[string]*tokenBucket
+	lastCleanup time.Time
+	mutex       sync.Mutex
+}
+
+// newRateLimiter Create a new limiter, returns nil when rate is not set (IOW no limit)
+func newRateLimiter(rate float64, burst int) *rateLimiter {
+	if rate <= 0 {
+		return nil
+	}
+	if burst < 1 {
+		burst = 1
+	}
+	return &rateLimiter{
+		rate:        rate,
+		burst:       float64(burst),
+		buckets:     make(map[string]*tokenBucket),
+		lastCleanup: time.Now(),
+		mutex:       sync.NewMutex(),
+	}
+}
+
+// Allow consumes one token of key bucket, returns false and the time to wait
+// before a new token is available when bucket is empty
+func (l *rateLimiter) Allow(key string) (bool, time.Duration) {
+	if l == nil {
+		return true, 0
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	now := time.Now()
+	if now.Sub(l.lastCleanup) > rateLimitCleanupPeriod {
+		for k, b := range l.buckets {
+			if now.Sub(b.last) > rateLimitIdleTime {
+				delete(l.buckets, k)
+			}
+		}
+		l.lastCleanup = now
+	}
+
+	b, exist := l.buckets[key]
+	if !exist {
+		b = &tokenBucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+	if b.tokens < 1 {
+		wait := time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+		return false, wait
+	}
+	b.tokens--
+	return true, 0
+}
+
+// RateLimits Hold all rate limiters of web server
+type RateLimits struct {
+	ip            *rateLimiter
+	session       *rateLimiter
+	expensive     *rateLimiter
+	event         *rateLimiter
+	sessionCreate *rateLimiter
+}
+
+// NewRateLimits Create rate limiters from configuration
+func NewRateLimits(cfg xdsconfig.RateLimitConf) *RateLimits {
+	return &RateLimits{
+		ip:            newRateLimiter(cfg.IPRate, cfg.IPBurst),
+		session:       newRateLimiter(cfg.SessionRate, cfg.SessionBurst),
+		expensive:     newRateLimiter(cfg.ExpensiveRate, cfg.ExpensiveBurst),
+		event:         newRateLimiter(cfg.EventRate, cfg.EventBurst),
+		sessionCreate: newRateLimiter(cfg.SessionCreateRate, cfg.SessionCreateBurst),
+	}
+}
+
+// AllowEvent returns true when a socket event sent by a session can be processed
+func (r *RateLimits) AllowEvent(sid string) bool {
+	ok, _ := r.event.Allow(sid)
+	return ok
+}
+
+// AllowSessionCreate returns true when a new session can be created for a client IP
+func (r *RateLimits) AllowSessionCreate(ip string) (bool, time.Duration) {
+	return r.sessionCreate.Allow(ip)
+}
+
+// isExpensiveRequest returns true for requests that use a separate (smaller) bucket
+func isExpensiveRequest(method, path string) bool {
+	if method != "POST" {
+		return false
+	}
+	p := strings.TrimPrefix(path, apiBaseURL)
+	return strings.HasPrefix(p, "/exec") || p == "/projects" || p == "/projects/" ||
+		p == "/projects/import" || p == "/projects/discover"
+}
+
+// clientIP returns IP address of a request
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// abortTooManyRequests aborts request with a 429 error
+func abortTooManyRequests(c *gin.Context, wait time.Duration, msg string) {
+	sec := int(math.Ceil(wait.Seconds()))
+	if sec < 1 {
+		sec = 1
+	}
+	c.Header("Retry-After", strconv.Itoa(sec))
+	c.JSON(http.StatusTooManyRequests, gin.H{"status": "error", "error": msg})
+	c.Abort()
+}
+
+// Rate limiting middleware per client IP, used before any other processing
+// (including session creation)
+func (s *WebServer) middlewareRateLimitIP() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !isProtectedPath(c.Request.URL.Path) {
+			c.Next()
+			return
+		}
+		if ok, wait := s.rateLimits.ip.Allow(clientIP(c.Request)); !ok {
+			abortTooManyRequests(c, wait, "Too many requests from this address")
+			return
+		}
+		c.Next()
+	}
+}
+
+// Rate limiting middleware per session, used before authentication
+func (s *WebServer) middlewareRateLimit() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !isProtectedPath(c.Request.URL.Path) {
+			c.Next()
+			return
+		}
+
+		if sid := s.sessions.GetID(c); sid != "" {
+			if ok, wait := s.rateLimits.session.Allow(sid); !ok {
+				abortTooManyRequests(c, wait, "Too many requests for this session")
+				return
+			}
+			if isExpensiveRequest(c.Request.Method, c.Request.URL.Path) {
+				if ok, wait := s.rateLimits.expensive.Allow(sid); !ok {
+					abortTooManyRequests(c, wait, "Too many requests for this operation")
+					return
+				}
+			}
+		}
+
+		c.Next()
+	}
+}
+
+// rateLimitedSocket Client socket whose inbound events are rate limited per
+// session (handlers registered with On are not called when limit is reached)
+type rateLimitedSocket struct {
+	socketio.Socket
+	ws  *WebServer
+	sid string
+}
+
+// On implements socketio.Socket interface
+func (so *rateLimitedSocket) On(event string, f interface{}) error {
+	fv := reflect.ValueOf(f)
+	if event == "disconnection" || event == "error" || fv.Kind() != reflect.Func {
+		return so.Socket.On(event, f)
+	}
+	ft := fv.Type()
+	wrapped := reflect.MakeFunc(ft, func(args []reflect.Value) []reflect.Value {
+		if !so.ws.rateLimits.AllowEvent(so.sid) {
+			so.ws.Log.Warningf("WS event %s dropped: rate limit reached (sid:%s)", event, so.sid)
+			res := make([]reflect.Value, ft.NumOut())
+			for i := range res {
+				res[i] = reflect.Zero(ft.Out(i))
+			}
+			return res
+		}
+		return fv.Call(args)
+	})
+	return so.Socket.On(event, wrapped.Interface())
+}
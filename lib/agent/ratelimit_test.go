@@ -0,0 +1,201 @@
+/*
+ * Copyright (C) 2017 "IoT.bzh"
+ * Author Sebastien Douheret <sebastien@iot.bzh>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package agent
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/googollee/go-socket.io"
+	"github.com/iotbzh/xds-agent/lib/xdsconfig"
+)
+
+func TestRateLimiter(t *testing.T) {
+	if ok, _ := newRateLimiter(0, 10).Allow("a"); !ok {
+		t.Errorf("limiter without rate must allow all requests")
+	}
+
+	l := newRateLimiter(10, 2)
+	for i := 0; i < 2; i++ {
+		if ok, _ := l.Allow("a"); !ok {
+			t.Fatalf("request %d must be allowed by burst", i)
+		}
+	}
+	ok, wait := l.Allow("a")
+	if ok || wait <= 0 || wait > 100*time.Millisecond {
+		t.Fatalf("request must be denied when bucket is empty (ok=%v, wait=%v)", ok, wait)
+	}
+	if ok, _ := l.Allow("b"); !ok {
+		t.Errorf("buckets of keys must be independent")
+	}
+
+	// Bucket is refilled after wait time
+	time.Sleep(wait + 20*time.Millisecond)
+	if ok, _ := l.Allow("a"); !ok {
+		t.Errorf("request must be allowed after refill")
+	}
+}
+
+func TestRateLimitIP(t *testing.T) {
+	ctx, cleanup := newTestRouterContext(t, func(conf *xdsconfig.FileConfig) {
+		conf.RateLimit = xdsconfig.RateLimitConf{IPRate: 10, IPBurst: 2}
+	})
+	defer cleanup()
+
+	remote := "192.168.1.10:4000"
+	get := func(addr string) *http.Response {
+		return serveRequest(ctx, newTestRequest("GET", apiBaseURL+"/version", addr)).Result()
+	}
+
+	// Unauthenticated requests are also limited
+	for i := 0; i < 2; i++ {
+		if r := get(remote); r.StatusCode != http.StatusUnauthorized {
+			t.Fatalf("request %d: status %d, want %d", i, r.StatusCode, http.StatusUnauthorized)
+		}
+	}
+	r := get(remote)
+	if r.StatusCode != http.StatusTooManyRequests || r.Header.Get("Retry-After") == "" {
+		t.Fatalf("burst used up: status %d (Retry-After '%s'), want %d",
+			r.StatusCode, r.Header.Get("Retry-After"), http.StatusTooManyRequests)
+	}
+	if r := get("192.168.1.11:4000"); r.StatusCode == http.StatusTooManyRequests {
+		t.Errorf("other address must not be limited")
+	}
+
+	// Static files are not limited
+	if w := serveRequest(ctx, newTestRequest("GET", "/", remote)); w.Code == http.StatusTooManyRequests {
+		t.Errorf("static files must not be limited")
+	}
+
+	time.Sleep(150 * time.Millisecond)
+	if r := get(remote); r.StatusCode == http.StatusTooManyRequests {
+		t.Errorf("request must be allowed after refill")
+	}
+}
+
+func TestRateLimitSession(t *testing.T) {
+	ctx, cleanup := newTestRouterContext(t, func(conf *xdsconfig.FileConfig) {
+		conf.XDSAPIKey = testAPIKey
+		conf.Auth.LocalhostBypass = true
+		conf.RateLimit = xdsconfig.RateLimitConf{
+			SessionRate:        10,
+			SessionBurst:       3,
+			ExpensiveRate:      0.01,
+			ExpensiveBurst:     1,
+			SessionCreateRate:  0.01,
+			SessionCreateBurst: 2,
+		}
+	})
+	defer cleanup()
+
+	local := "127.0.0.1:4000"
+	get := func(sid string) *http.Response {
+		req := newTestJSONRequest("GET", apiBaseURL+"/version", local, sid, "")
+		return serveRequest(ctx, req).Result()
+	}
+
+	// Session creation
+	sid := get("").Header.Get(sessionHeaderName)
+	if sid == "" {
+		t.Fatalf("session not created")
+	}
+	if r := get(""); r.StatusCode != http.StatusOK {
+		t.Fatalf("second session: status %d, want %d", r.StatusCode, http.StatusOK)
+	}
+	if r := get(""); r.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("session creation: status %d, want %d", r.StatusCode, http.StatusTooManyRequests)
+	}
+
+	// Session requests (session bucket already used by creation request)
+	for i := 0; i < 2; i++ {
+		if r := get(sid); r.StatusCode != http.StatusOK {
+			t.Fatalf("request %d: status %d, want %d", i, r.StatusCode, http.StatusOK)
+		}
+	}
+	if r := get(sid); r.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("session burst used up: status %d, want %d", r.StatusCode, http.StatusTooManyRequests)
+	}
+
+	// Expensive requests (once session bucket is full again)
+	time.Sleep(350 * time.Millisecond)
+	post := func() int {
+		req := newTestJSONRequest("POST", apiBaseURL+"/projects", local, sid, `{"label": "test"}`)
+		req.Header.Set(apiKeyHeaderName, testAPIKey)
+		return serveRequest(ctx, req).Code
+	}
+	if code := post(); code == http.StatusTooManyRequests {
+		t.Fatalf("first expensive request must be allowed")
+	}
+	if code := post(); code != http.StatusTooManyRequests {
+		t.Fatalf("expensive burst used up: status %d, want %d", code, http.StatusTooManyRequests)
+	}
+	if r := get(sid); r.StatusCode != http.StatusOK {
+		t.Errorf("cheap request after expensive limit: status %d, want %d", r.StatusCode, http.StatusOK)
+	}
+}
+
+// testSocket Socket that only records registered handlers
+type testSocket struct {
+	socketio.Socket
+	handlers map[string]interface{}
+}
+
+func (so *testSocket) On(event string, f interface{}) error {
+	so.handlers[event] = f
+	return nil
+}
+
+func TestRateLimitSocketEvents(t *testing.T) {
+	ctx, cleanup := newTestRouterContext(t, func(conf *xdsconfig.FileConfig) {
+		conf.RateLimit = xdsconfig.RateLimitConf{EventRate: 10, EventBurst: 2}
+	})
+	defer cleanup()
+
+	sock := &testSocket{handlers: make(map[string]interface{})}
+	so := &rateLimitedSocket{Socket: sock, ws: ctx.webServer, sid: "1234"}
+
+	calls := 0
+	so.On("exec:input", func(data string) string {
+		calls++
+		return data
+	})
+	disconnections := 0
+	so.On("disconnection", func() { disconnections++ })
+
+	input := sock.handlers["exec:input"].(func(string) string)
+	for i := 0; i < 2; i++ {
+		if res := input("ls"); res != "ls" {
+			t.Fatalf("event %d: result '%s', want 'ls'", i, res)
+		}
+	}
+	if res := input("ls"); res != "" || calls != 2 {
+		t.Fatalf("event must be dropped when burst is used up (result '%s', calls %d)", res, calls)
+	}
+
+	// Disconnection is never dropped
+	disconnect := sock.handlers["disconnection"].(func())
+	disconnect()
+	if disconnections != 1 {
+		t.Errorf("disconnection event must not be limited")
+	}
+
+	time.Sleep(150 * time.Millisecond)
+	if res := input("ls"); res != "ls" || calls != 3 {
+		t.Errorf("event must be handled after refill (result '%s', calls %d)", res, calls)
+	}
+}
@@ -0,0 +1,154 @@
+/*
+ * Copyright (C) 2017 "IoT.bzh"
+ * Author Sebastien Douheret <sebastien@iot.bzh>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package agent
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	common "github.com/iotbzh/xds-common/golib"
+)
+
+// storedSession Persistent part of a client session
+type storedSession struct {
+	ID         string        `json:"id"`
+	MaxAge     int64         `json:"maxAge"`
+	CreatedAt  time.Time     `json:"createdAt"`
+	ExpireAt   time.Time     `json:"expireAt"`
+	UseCount   int64         `json:"useCount"`
+	CsrfToken  string        `json:"csrfToken"`
+	RemoteAddr string        `json:"remoteAddr"`
+	UserAgent  string        `json:"userAgent"`
+	Identity   *AuthIdentity `json:"identity,omitempty"`
+	Events     []string      `json:"events"`
+}
+
+// isPersistent returns true when sessions are saved across agent restarts
+func (s *Sessions) isPersistent() bool {
+	return s.Config.FileConf.SessionsConf.PersistFile != ""
+}
+
+// setDirty Mark sessions as modified (must be called with mutex locked)
+func (s *Sessions) setDirty() {
+	s.dirty = true
+}
+
+// MarkDirty Mark sessions as modified, so they will be saved on next monitoring tick
+func (s *Sessions) MarkDirty() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.setDirty()
+}
+
+// Save writes sessions and their event registrations into persistence file
+func (s *Sessions) Save() error {
+	if !s.isPersistent() {
+		return nil
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	list := []storedSession{}
+	for _, ss := range s.sessMap {
+		if ss.expireAt.Sub(time.Now()) < 0 {
+			continue
+		}
+		list = append(list, storedSession{
+			ID:         ss.ID,
+			MaxAge:     ss.MaxAge,
+			CreatedAt:  ss.createdAt,
+			ExpireAt:   ss.expireAt,
+			UseCount:   ss.useCount,
+			CsrfToken:  ss.csrfToken,
+			RemoteAddr: ss.remoteAddr,
+			UserAgent:  ss.userAgent,
+			Identity:   ss.Identity,
+			Events:     s.events.GetRegistered(ss.ID),
+		})
+	}
+
+	data, err := json.Marshal(list)
+	if err != nil {
+		return err
+	}
+
+	file := s.Config.FileConf.SessionsConf.PersistFile
+	if err := os.MkdirAll(filepath.Dir(file), 0700); err != nil {
+		return fmt.Errorf("Cannot create sessions directory: %v", err)
+	}
+	tmpFile := file + ".tmp"
+	if err := ioutil.WriteFile(tmpFile, data, 0600); err != nil {
+		return fmt.Errorf("Cannot save sessions: %v", err)
+	}
+	if err := os.Rename(tmpFile, file); err != nil {
+		return err
+	}
+	s.dirty = false
+
+	s.LogSillyf("Sessions saved: %d", len(list))
+	return nil
+}
+
+// load restores sessions and their event registrations from persistence file
+func (s *Sessions) load() error {
+	file := s.Config.FileConf.SessionsConf.PersistFile
+	if !common.Exists(file) {
+		return nil
+	}
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	list := []storedSession{}
+	if err := json.Unmarshal(data, &list); err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for _, ss := range list {
+		if ss.ExpireAt.Sub(time.Now()) < 0 {
+			continue
+		}
+		s.sessMap[ss.ID] = ClientSession{
+			ID:         ss.ID,
+			MaxAge:     ss.MaxAge,
+			Identity:   ss.Identity,
+			createdAt:  ss.CreatedAt,
+			expireAt:   ss.ExpireAt,
+			useCount:   ss.UseCount,
+			csrfToken:  ss.CsrfToken,
+			remoteAddr: ss.RemoteAddr,
+			userAgent:  ss.UserAgent,
+		}
+		for _, ev := range ss.Events {
+			if err := s.events.Register(ev, ss.ID); err != nil {
+				s.Log.Warningf("Cannot restore event %s registration of session %s: %v", ev, ss.ID, err)
+			}
+		}
+	}
+
+	s.Log.Infof("Number of restored sessions: %d", len(s.sessMap))
+	return nil
+}
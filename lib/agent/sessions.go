@@ -18,12 +18,18 @@
 package agent
 
 import (
+	"crypto/rand"
+	"crypto/subtle"
 	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/url"
 	"strconv"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/googollee/go-socket.io"
+	"github.com/iotbzh/xds-agent/lib/xaapiv1"
 	uuid "github.com/satori/go.uuid"
 	"github.com/syncthing/syncthing/lib/sync"
 )
@@ -31,31 +37,40 @@ import (
 const sessionCookieName = "xds-agent-sid"
 const sessionHeaderName = "XDS-AGENT-SID"
 
+const csrfCookieName = "xds-agent-csrf"
+const csrfHeaderName = "X-CSRF-Token"
+
 const sessionMonitorTime = 10 // Time (in seconds) to schedule monitoring session tasks
 
 const initSessionMaxAge = 10 // Initial session max age in seconds
 const maxSessions = 100000   // Maximum number of sessions in sessMap map
 
-const secureCookie = false // TODO: see https://github.com/astaxie/beego/blob/master/session/session.go#L218
-
 // ClientSession contains the info of a user/client session
 type ClientSession struct {
 	ID       string
 	WSID     string // only one WebSocket per client/session
 	MaxAge   int64
 	IOSocket *socketio.Socket
+	Identity *AuthIdentity // identity of authenticated client (nil if unknown)
 
 	// private
-	expireAt time.Time
-	useCount int64
+	createdAt  time.Time
+	expireAt   time.Time
+	useCount   int64
+	csrfToken  string
+	remoteAddr string
+	userAgent  string
+	cmdIDs     []string // running commands started by this session
 }
 
 // Sessions holds client sessions
 type Sessions struct {
 	*Context
 	cookieMaxAge int64
+	maxSessions  int
 	sessMap      map[string]ClientSession
 	mutex        sync.Mutex
+	dirty        bool          // sessions modified since last save
 	stop         chan struct{} // signals intentional stop
 }
 
@@ -65,15 +80,27 @@ func NewClientSessions(ctx *Context, cookieMaxAge string) *Sessions {
 	if err != nil {
 		ckMaxAge = 0
 	}
+	maxSess := ctx.Config.FileConf.SessionsConf.MaxSessions
+	if maxSess <= 0 {
+		maxSess = maxSessions
+	}
 	s := Sessions{
 		Context:      ctx,
 		cookieMaxAge: ckMaxAge,
+		maxSessions:  maxSess,
 		sessMap:      make(map[string]ClientSession),
 		mutex:        sync.NewMutex(),
 		stop:         make(chan struct{}),
 	}
 	s.webServer.router.Use(s.Middleware())
 
+	// Restore sessions saved before last agent stop
+	if s.isPersistent() {
+		if err := s.load(); err != nil {
+			s.Log.Errorf("Cannot restore sessions: %v", err)
+		}
+	}
+
 	// Start monitoring of sessions Map (use to manage expiration and cleanup)
 	go s.monitorSessMap()
 
@@ -88,23 +115,35 @@ func (s *Sessions) Stop() {
 // Middleware is used to managed session
 func (s *Sessions) Middleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		// FIXME Add CSRF management
-
 		// Get session
 		sess := s.Get(c)
 		if sess == nil {
+			// Limit sessions creation to prevent flooding
+			if ok, wait := s.webServer.rateLimits.AllowSessionCreate(clientIP(c.Request)); !ok {
+				abortTooManyRequests(c, wait, "Too many new sessions from this address")
+				return
+			}
+
 			// Allocate a new session key and put in cookie
-			sess = s.newSession("")
+			if sess = s.newSession("", c.Request.RemoteAddr, c.Request.UserAgent()); sess == nil {
+				abortTooManyRequests(c, sessionMonitorTime*time.Second, "Too many sessions")
+				return
+			}
 		} else {
 			s.refresh(sess.ID)
 		}
 
 		// Set session in cookie and in header
 		// Do not set Domain to localhost (http://stackoverflow.com/questions/1134290/cookies-on-localhost-with-explicit-domain)
-		c.SetCookie(sessionCookieName, sess.ID, int(sess.MaxAge), "/", "",
-			secureCookie, false)
+		s.setCookie(c, sessionCookieName, sess.ID, int(sess.MaxAge))
 		c.Header(sessionHeaderName, sess.ID)
 
+		// Set CSRF token bound to this session (double-submit cookie: client
+		// must send back this value in X-CSRF-Token header, see middlewareCSRF)
+		// Cookie is readable by javascript on purpose
+		s.setCookie(c, csrfCookieName, sess.csrfToken, int(sess.MaxAge))
+		c.Header(csrfHeaderName, sess.csrfToken)
+
 		// Save session id in gin metadata
 		c.Set(sessionCookieName, sess.ID)
 
@@ -112,6 +151,25 @@ func (s *Sessions) Middleware() gin.HandlerFunc {
 	}
 }
 
+// setCookie Set a cookie in response, Secure and SameSite flags are set when
+// web server uses HTTPS
+func (s *Sessions) setCookie(c *gin.Context, name, value string, maxAge int) {
+	secure := s.webServer.isTLSEnabled()
+	ck := http.Cookie{
+		Name:   name,
+		Value:  url.QueryEscape(value),
+		MaxAge: maxAge,
+		Path:   "/",
+		Secure: secure,
+	}
+	// Add SameSite attribute by hand (not supported by all Go versions)
+	v := ck.String()
+	if secure {
+		v += "; SameSite=Strict"
+	}
+	c.Writer.Header().Add("Set-Cookie", v)
+}
+
 // Get returns the client session for a specific ID
 func (s *Sessions) Get(c *gin.Context) *ClientSession {
 	var sid string
@@ -179,27 +237,165 @@ func (s *Sessions) UpdateIOSocket(sid string, so *socketio.Socket) error {
 	return nil
 }
 
+// IsValidCSRFToken returns true when token matches the CSRF token of a session
+func (s *Sessions) IsValidCSRFToken(sid, token string) bool {
+	if sid == "" || token == "" {
+		return false
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	sess, ok := s.sessMap[sid]
+	if !ok || sess.csrfToken == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(token), []byte(sess.csrfToken)) == 1
+}
+
+// newCSRFToken Generate a new random CSRF token
+func newCSRFToken() string {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		// Should never happen, fallback on a random uuid
+		return base64.URLEncoding.EncodeToString(uuid.NewV4().Bytes())
+	}
+	return base64.URLEncoding.EncodeToString(b)
+}
+
+// SetIdentity attaches an authenticated identity to a session
+func (s *Sessions) SetIdentity(sid string, ident *AuthIdentity) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if sess, ok := s.sessMap[sid]; ok {
+		if sess.Identity == nil || sess.Identity.Name != ident.Name {
+			s.setDirty()
+		}
+		sess.Identity = ident
+		s.sessMap[sid] = sess
+	}
+}
+
+// GetIdentityName returns the name of identity attached to a session
+func (s *Sessions) GetIdentityName(sid string) string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if sess, ok := s.sessMap[sid]; ok && sess.Identity != nil {
+		return sess.Identity.Name
+	}
+	return ""
+}
+
 // newSession Allocate a new client session
-func (s *Sessions) newSession(prefix string) *ClientSession {
+func (s *Sessions) newSession(prefix, remoteAddr, userAgent string) *ClientSession {
 	uuid := prefix + uuid.NewV4().String()
 	id := base64.URLEncoding.EncodeToString([]byte(uuid))
 	se := ClientSession{
-		ID:       id,
-		WSID:     "",
-		MaxAge:   initSessionMaxAge,
-		IOSocket: nil,
-		expireAt: time.Now().Add(time.Duration(initSessionMaxAge) * time.Second),
-		useCount: 0,
+		ID:         id,
+		WSID:       "",
+		MaxAge:     initSessionMaxAge,
+		IOSocket:   nil,
+		createdAt:  time.Now(),
+		expireAt:   time.Now().Add(time.Duration(initSessionMaxAge) * time.Second),
+		useCount:   0,
+		csrfToken:  newCSRFToken(),
+		remoteAddr: remoteAddr,
+		userAgent:  userAgent,
 	}
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 
+	if len(s.sessMap) >= s.maxSessions {
+		s.Log.Errorf("Cannot create new session: maximum number of sessions reached (%d)", s.maxSessions)
+		return nil
+	}
+
 	s.sessMap[se.ID] = se
+	s.setDirty()
 
 	s.Log.Debugf("NEW session (%d): %s", len(s.sessMap), id)
 	return &se
 }
 
+// GetList returns the description of all sessions
+func (s *Sessions) GetList() []xaapiv1.SessionInfo {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	list := []xaapiv1.SessionInfo{}
+	for _, ss := range s.sessMap {
+		list = append(list, s.sessionInfo(ss))
+	}
+	return list
+}
+
+// Delete force-expires a session: its socket is closed and session is cleaned
+// up as on a disconnection
+func (s *Sessions) Delete(sid string) (xaapiv1.SessionInfo, error) {
+	s.mutex.Lock()
+	ss, exist := s.sessMap[sid]
+	if !exist {
+		s.mutex.Unlock()
+		return xaapiv1.SessionInfo{}, fmt.Errorf("Unknown session id")
+	}
+	info := s.sessionInfo(ss)
+	delete(s.sessMap, sid)
+	s.setDirty()
+	s.mutex.Unlock()
+
+	// Same cleanup than socket disconnection (see socketHandler)
+	s.events.UnRegister(xaapiv1.EVTAll, sid)
+	if ss.IOSocket != nil {
+		(*ss.IOSocket).Disconnect()
+	}
+
+	s.Log.Infof("Session %s deleted (WSID=%s)", sid, ss.WSID)
+	return info, nil
+}
+
+// CommandAdd Add a command to the list of running commands of a session
+func (s *Sessions) CommandAdd(sid, cmdID string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if sess, ok := s.sessMap[sid]; ok {
+		sess.cmdIDs = append(append([]string{}, sess.cmdIDs...), cmdID)
+		s.sessMap[sid] = sess
+	}
+}
+
+// CommandDelete Remove a command from the list of running commands of a session
+func (s *Sessions) CommandDelete(sid, cmdID string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if sess, ok := s.sessMap[sid]; ok {
+		ids := []string{}
+		for _, id := range sess.cmdIDs {
+			if id != cmdID {
+				ids = append(ids, id)
+			}
+		}
+		sess.cmdIDs = ids
+		s.sessMap[sid] = sess
+	}
+}
+
+// sessionInfo returns public description of a session (must be called with mutex locked)
+func (s *Sessions) sessionInfo(ss ClientSession) xaapiv1.SessionInfo {
+	user := ""
+	if ss.Identity != nil {
+		user = ss.Identity.Name
+	}
+	return xaapiv1.SessionInfo{
+		ID:         ss.ID,
+		WSID:       ss.WSID,
+		User:       user,
+		RemoteAddr: ss.remoteAddr,
+		UserAgent:  ss.userAgent,
+		CreatedAt:  ss.createdAt.Format(time.RFC3339),
+		ExpireAt:   ss.expireAt.Format(time.RFC3339),
+		Events:     s.events.GetRegistered(ss.ID),
+		Commands:   append([]string{}, ss.cmdIDs...),
+	}
+}
+
 // refresh Move this session ID to the head of the list
 func (s *Sessions) refresh(sid string) {
 	s.mutex.Lock()
@@ -210,11 +406,9 @@ func (s *Sessions) refresh(sid string) {
 	if sess.MaxAge < s.cookieMaxAge && sess.useCount > 1 {
 		sess.MaxAge = s.cookieMaxAge
 		sess.expireAt = time.Now().Add(time.Duration(sess.MaxAge) * time.Second)
+		s.setDirty()
 	}
 
-	// TODO - Add flood detection (like limit_req of nginx)
-	// (delayed request when to much requests in a short period of time)
-
 	s.sessMap[sid] = sess
 }
 
@@ -228,18 +422,32 @@ func (s *Sessions) monitorSessMap() {
 			s.LogSillyf("Sessions Map size: %d", len(s.sessMap))
 			s.LogSillyf("Sessions Map : %v", s.sessMap)
 
-			if len(s.sessMap) > maxSessions {
+			if len(s.sessMap) >= s.maxSessions {
 				s.Log.Errorln("TOO MUCH sessions, cleanup old ones !")
 			}
 
 			s.mutex.Lock()
+			expired := []string{}
 			for _, ss := range s.sessMap {
 				if ss.expireAt.Sub(time.Now()) < 0 {
 					s.LogSillyf("Delete expired session id: %s", ss.ID)
 					delete(s.sessMap, ss.ID)
+					expired = append(expired, ss.ID)
+					s.setDirty()
 				}
 			}
+			dirty := s.dirty
 			s.mutex.Unlock()
+
+			for _, sid := range expired {
+				s.events.UnRegister(xaapiv1.EVTAll, sid)
+			}
+
+			if dirty {
+				if err := s.Save(); err != nil {
+					s.Log.Errorf("Cannot save sessions: %v", err)
+				}
+			}
 		}
 	}
 }
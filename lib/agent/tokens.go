@@ -0,0 +1,272 @@
+/*
+ * Copyright (C) 2017 "IoT.bzh"
+ * Author Sebastien Douheret <sebastien@iot.bzh>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package agent
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/iotbzh/xds-agent/lib/xaapiv1"
+	common "github.com/iotbzh/xds-common/golib"
+	uuid "github.com/satori/go.uuid"
+	"github.com/syncthing/syncthing/lib/sync"
+)
+
+const tokenPrefix = "xdsat_"
+
+// AuthIdentity Identity of an authenticated client
+type AuthIdentity struct {
+	Name    string   `json:"name"`
+	TokenID string   `json:"tokenId"`
+	Scopes  []string `json:"scopes"`
+}
+
+// HasScope returns true when identity is granted a scope (admin grants all scopes)
+func (a *AuthIdentity) HasScope(scope string) bool {
+	for _, sc := range a.Scopes {
+		if sc == scope || sc == xaapiv1.TokenScopeAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+// tokenEntry On-disk representation of a token (only hash of secret is saved)
+type tokenEntry struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	Hash      string    `json:"hash"`
+	Scopes    []string  `json:"scopes"`
+	CreatedAt time.Time `json:"createdAt"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// Tokens Store of issued access tokens
+type Tokens struct {
+	*Context
+	file    string
+	entries map[string]*tokenEntry // key is token hash
+	mutex   sync.Mutex
+}
+
+// NewTokens Create a new instance of tokens store and load saved tokens
+func NewTokens(ctx *Context) *Tokens {
+	t := Tokens{
+		Context: ctx,
+		file:    ctx.Config.FileConf.Auth.TokensFile,
+		entries: make(map[string]*tokenEntry),
+		mutex:   sync.NewMutex(),
+	}
+
+	if err := t.load(); err != nil {
+		t.Log.Errorf("Cannot load access tokens from %s: %v", t.file, err)
+	}
+	return &t
+}
+
+// Create issues a new token, returns its description and its secret value
+func (t *Tokens) Create(args xaapiv1.TokenCreateArgs) (*xaapiv1.TokenCreateResult, error) {
+	if args.Name == "" {
+		return nil, fmt.Errorf("Token name must be set")
+	}
+	if len(args.Scopes) == 0 {
+		args.Scopes = []string{xaapiv1.TokenScopeRead}
+	}
+	for _, sc := range args.Scopes {
+		if !isValidScope(sc) {
+			return nil, fmt.Errorf("Unsupported scope: %s", sc)
+		}
+	}
+	if args.ExpiresIn < 0 {
+		return nil, fmt.Errorf("Invalid expiresIn value")
+	}
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	secret := tokenPrefix + hex.EncodeToString(b)
+
+	ent := tokenEntry{
+		ID:        uuid.NewV4().String(),
+		Name:      args.Name,
+		Hash:      hashToken(secret),
+		Scopes:    args.Scopes,
+		CreatedAt: time.Now(),
+	}
+	if args.ExpiresIn > 0 {
+		ent.ExpiresAt = ent.CreatedAt.Add(time.Duration(args.ExpiresIn) * time.Second)
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.entries[ent.Hash] = &ent
+	if err := t.save(); err != nil {
+		delete(t.entries, ent.Hash)
+		return nil, err
+	}
+
+	t.Log.Infof("AUDIT: new access token id=%s name=%s scopes=%v", ent.ID, ent.Name, ent.Scopes)
+
+	return &xaapiv1.TokenCreateResult{TokenInfo: ent.info(), Token: secret}, nil
+}
+
+// List returns the description of all valid tokens
+func (t *Tokens) List() []xaapiv1.TokenInfo {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	list := []xaapiv1.TokenInfo{}
+	for _, ent := range t.entries {
+		if !ent.isExpired() {
+			list = append(list, ent.info())
+		}
+	}
+	return list
+}
+
+// Revoke deletes a token
+func (t *Tokens) Revoke(id string) (xaapiv1.TokenInfo, error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	for h, ent := range t.entries {
+		if ent.ID == id {
+			delete(t.entries, h)
+			if err := t.save(); err != nil {
+				t.entries[h] = ent
+				return xaapiv1.TokenInfo{}, err
+			}
+			t.Log.Infof("AUDIT: access token revoked id=%s name=%s", ent.ID, ent.Name)
+			return ent.info(), nil
+		}
+	}
+	return xaapiv1.TokenInfo{}, fmt.Errorf("Unknown token id")
+}
+
+// Authenticate returns the identity associated to a token or nil when invalid
+func (t *Tokens) Authenticate(secret string) *AuthIdentity {
+	if secret == "" {
+		return nil
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	ent, exist := t.entries[hashToken(secret)]
+	if !exist || ent.isExpired() {
+		return nil
+	}
+	return &AuthIdentity{
+		Name:    ent.Name,
+		TokenID: ent.ID,
+		Scopes:  ent.Scopes,
+	}
+}
+
+/***
+** Private functions
+***/
+
+func (e *tokenEntry) isExpired() bool {
+	return !e.ExpiresAt.IsZero() && time.Now().After(e.ExpiresAt)
+}
+
+func (e *tokenEntry) info() xaapiv1.TokenInfo {
+	ti := xaapiv1.TokenInfo{
+		ID:        e.ID,
+		Name:      e.Name,
+		Scopes:    e.Scopes,
+		CreatedAt: e.CreatedAt.Format(time.RFC3339),
+	}
+	if !e.ExpiresAt.IsZero() {
+		ti.ExpiresAt = e.ExpiresAt.Format(time.RFC3339)
+	}
+	return ti
+}
+
+func hashToken(secret string) string {
+	h := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(h[:])
+}
+
+func isValidScope(scope string) bool {
+	for _, sc := range xaapiv1.TokenScopesList {
+		if sc == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// load reads tokens file
+func (t *Tokens) load() error {
+	if t.file == "" || !common.Exists(t.file) {
+		return nil
+	}
+	data, err := ioutil.ReadFile(t.file)
+	if err != nil {
+		return err
+	}
+	list := []tokenEntry{}
+	if err := json.Unmarshal(data, &list); err != nil {
+		return err
+	}
+	for i := range list {
+		ent := list[i]
+		if !ent.isExpired() {
+			t.entries[ent.Hash] = &ent
+		}
+	}
+	t.Log.Infof("Number of loaded access tokens: %d", len(t.entries))
+	return nil
+}
+
+// save writes tokens file atomically (must be called with mutex locked)
+func (t *Tokens) save() error {
+	if t.file == "" {
+		return nil
+	}
+	list := []tokenEntry{}
+	for _, ent := range t.entries {
+		if !ent.isExpired() {
+			list = append(list, *ent)
+		}
+	}
+	data, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(t.file), 0700); err != nil {
+		return fmt.Errorf("Cannot create tokens directory: %v", err)
+	}
+	tmpFile := t.file + ".tmp"
+	if err := ioutil.WriteFile(tmpFile, data, 0600); err != nil {
+		return fmt.Errorf("Cannot save tokens: %v", err)
+	}
+	return os.Rename(tmpFile, t.file)
+}
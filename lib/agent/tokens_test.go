@@ -0,0 +1,151 @@
+/*
+ * Copyright (C) 2017 "IoT.bzh"
+ * Author Sebastien Douheret <sebastien@iot.bzh>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package agent
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/iotbzh/xds-agent/lib/xaapiv1"
+)
+
+func TestTokensStore(t *testing.T) {
+	ctx, cleanup := newTestContext(t)
+	defer cleanup()
+
+	if _, err := ctx.tokens.Create(xaapiv1.TokenCreateArgs{}); err == nil {
+		t.Errorf("token without name must be rejected")
+	}
+	if _, err := ctx.tokens.Create(xaapiv1.TokenCreateArgs{Name: "bad", Scopes: []string{"root"}}); err == nil {
+		t.Errorf("token with unsupported scope must be rejected")
+	}
+
+	res, err := ctx.tokens.Create(xaapiv1.TokenCreateArgs{Name: "ci", Scopes: []string{xaapiv1.TokenScopeExec}})
+	if err != nil {
+		t.Fatalf("cannot create token: %v", err)
+	}
+	if !strings.HasPrefix(res.Token, tokenPrefix) || res.ID == "" || res.ExpiresAt != "" {
+		t.Errorf("invalid created token: %+v", res)
+	}
+
+	id := ctx.tokens.Authenticate(res.Token)
+	if id == nil || id.TokenID != res.ID || !id.HasScope(xaapiv1.TokenScopeExec) {
+		t.Fatalf("invalid identity of token: %+v", id)
+	}
+	if id.HasScope(xaapiv1.TokenScopeProjectsWrite) {
+		t.Errorf("token must only be granted its scopes")
+	}
+	if ctx.tokens.Authenticate(res.Token+"x") != nil || ctx.tokens.Authenticate("") != nil {
+		t.Errorf("invalid secret must not be authenticated")
+	}
+
+	// Only hash of secret is saved
+	tokFile := ctx.Config.FileConf.Auth.TokensFile
+	fi, err := os.Stat(tokFile)
+	if err != nil {
+		t.Fatalf("tokens file not saved: %v", err)
+	}
+	if fi.Mode().Perm() != 0600 {
+		t.Errorf("invalid tokens file mode: %v", fi.Mode().Perm())
+	}
+	data, _ := ioutil.ReadFile(tokFile)
+	if strings.Contains(string(data), res.Token) || !strings.Contains(string(data), hashToken(res.Token)) {
+		t.Errorf("tokens file must only contain hash of secret: %s", data)
+	}
+
+	// Saved tokens are reloaded
+	reloaded := NewTokens(ctx)
+	if id := reloaded.Authenticate(res.Token); id == nil || id.TokenID != res.ID {
+		t.Fatalf("token not reloaded: %+v", id)
+	}
+	if l := reloaded.List(); len(l) != 1 || l[0].Name != "ci" {
+		t.Errorf("invalid tokens list: %+v", l)
+	}
+
+	// Revoked token is rejected, also after reload
+	if _, err := reloaded.Revoke("unknown"); err == nil {
+		t.Errorf("revoke of unknown token must fail")
+	}
+	if _, err := reloaded.Revoke(res.ID); err != nil {
+		t.Fatalf("cannot revoke token: %v", err)
+	}
+	if reloaded.Authenticate(res.Token) != nil {
+		t.Errorf("revoked token must be rejected")
+	}
+	if NewTokens(ctx).Authenticate(res.Token) != nil {
+		t.Errorf("revoked token must be rejected after reload")
+	}
+}
+
+func TestTokensScope(t *testing.T) {
+	ctx, cleanup := newTestRouterContext(t, nil)
+	defer cleanup()
+
+	newToken := func(scope string) string {
+		res, err := ctx.tokens.Create(xaapiv1.TokenCreateArgs{Name: scope, Scopes: []string{scope}})
+		if err != nil {
+			t.Fatalf("cannot create token: %v", err)
+		}
+		return res.Token
+	}
+	readTok := newToken(xaapiv1.TokenScopeRead)
+	writeTok := newToken(xaapiv1.TokenScopeProjectsWrite)
+	adminTok := newToken(xaapiv1.TokenScopeAdmin)
+
+	remote := "192.168.1.10:4000"
+	project := `{"label": "test", "type": "PathMap", "clientPath": "/tmp"}`
+	for _, tt := range []struct {
+		name   string
+		token  string
+		method string
+		path   string
+		denied bool
+	}{
+		{"read token GET", readTok, "GET", "/version", false},
+		{"read token POST projects", readTok, "POST", "/projects", true},
+		{"read token list tokens", readTok, "GET", "/tokens", true},
+		{"projects:write token POST projects", writeTok, "POST", "/projects", false},
+		{"projects:write token exec", writeTok, "POST", "/exec", true},
+		{"admin token list tokens", adminTok, "GET", "/tokens", false},
+	} {
+		req := newTestJSONRequest(tt.method, apiBaseURL+tt.path, remote, "", project)
+		req.Header.Set(apiKeyHeaderName, tt.token)
+		w := serveRequest(ctx, req)
+		if denied := w.Code == http.StatusForbidden || w.Code == http.StatusUnauthorized; denied != tt.denied {
+			t.Errorf("%s: status %d, denied must be %v (%s)", tt.name, w.Code, tt.denied, w.Body.String())
+		}
+		if tt.denied && !strings.Contains(w.Body.String(), "scope") {
+			t.Errorf("%s: missing scope not reported: %s", tt.name, w.Body.String())
+		}
+	}
+
+	// Token revoked through API is rejected
+	id := ctx.tokens.Authenticate(readTok)
+	req := newTestRequest("DELETE", apiBaseURL+"/tokens/"+id.TokenID, remote)
+	req.Header.Set(apiKeyHeaderName, adminTok)
+	if w := serveRequest(ctx, req); w.Code != http.StatusOK {
+		t.Fatalf("cannot revoke token: status %d (%s)", w.Code, w.Body.String())
+	}
+	req = newTestRequest("GET", apiBaseURL+"/version", remote)
+	req.Header.Set(apiKeyHeaderName, readTok)
+	if w := serveRequest(ctx, req); w.Code != http.StatusForbidden {
+		t.Errorf("revoked token: status %d, want %d", w.Code, http.StatusForbidden)
+	}
+}
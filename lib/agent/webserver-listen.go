@@ -0,0 +1,262 @@
+/*
+ * Copyright (C) 2017 "IoT.bzh"
+ * Author Sebastien Douheret <sebastien@iot.bzh>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package agent
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/iotbzh/xds-agent/lib/xdsconfig"
+)
+
+const unixSocketPrefix = "unix:"
+const defaultUnixSocketMode = 0600
+
+type ctxKey string
+
+// unixSocketCtxKey Request context key set for requests received on a unix socket
+const unixSocketCtxKey = ctxKey("xds-unix-socket")
+
+// webListener Listen endpoint of web server
+type webListener struct {
+	ln       net.Listener
+	handler  http.Handler
+	desc     string
+	sockPath string // path of unix socket (empty for TCP)
+}
+
+// listenEndpoints returns configured listen endpoints (default on all interfaces on httpPort)
+func (s *WebServer) listenEndpoints() []xdsconfig.ListenConf {
+	if len(s.Config.FileConf.Listen) > 0 {
+		return s.Config.FileConf.Listen
+	}
+	return []xdsconfig.ListenConf{{Address: ":" + s.Config.FileConf.HTTPPort}}
+}
+
+// httpsPort returns the port of the first TCP endpoint
+func (s *WebServer) httpsPort() string {
+	for _, ep := range s.listenEndpoints() {
+		if strings.HasPrefix(ep.Address, unixSocketPrefix) {
+			continue
+		}
+		if _, port, err := net.SplitHostPort(ep.Address); err == nil {
+			return port
+		}
+	}
+	return s.Config.FileConf.HTTPPort
+}
+
+// agentURL returns address (host:port) used by local clients to reach agent,
+// derived from the first TCP endpoint
+func (s *WebServer) agentURL() string {
+	for _, ep := range s.listenEndpoints() {
+		if strings.HasPrefix(ep.Address, unixSocketPrefix) {
+			continue
+		}
+		host, port, err := net.SplitHostPort(ep.Address)
+		if err != nil {
+			continue
+		}
+		if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+			host = "localhost"
+		}
+		return net.JoinHostPort(host, port)
+	}
+	return "localhost:" + s.Config.FileConf.HTTPPort
+}
+
+// createListeners opens all listen endpoints
+func (s *WebServer) createListeners() ([]webListener, error) {
+	var tlsConfig *tls.Config
+	if s.isTLSEnabled() {
+		if err := s.loadCertificate(); err != nil {
+			return nil, err
+		}
+		tlsConfig = &tls.Config{GetCertificate: s.getCertificate}
+	}
+
+	list := []webListener{}
+	closeAll := func() {
+		for _, wl := range list {
+			wl.ln.Close()
+		}
+	}
+
+	for _, ep := range s.listenEndpoints() {
+		var wl webListener
+		var err error
+
+		if strings.HasPrefix(ep.Address, unixSocketPrefix) {
+			wl, err = s.listenUnix(strings.TrimPrefix(ep.Address, unixSocketPrefix), ep.Mode)
+		} else {
+			wl, err = s.listenTCP(ep.Address, tlsConfig)
+		}
+		if err != nil {
+			closeAll()
+			return nil, err
+		}
+		list = append(list, wl)
+	}
+
+	// HTTP redirection to HTTPS, on the same addresses than TCP endpoints
+	if s.isTLSEnabled() && s.Config.FileConf.TLS.HTTPRedirectPort != "" {
+		hosts := make(map[string]bool)
+		for _, ep := range s.listenEndpoints() {
+			if strings.HasPrefix(ep.Address, unixSocketPrefix) {
+				continue
+			}
+			host, _, err := net.SplitHostPort(ep.Address)
+			if err != nil || hosts[host] {
+				continue
+			}
+			hosts[host] = true
+			wl, err := s.listenRedirect(net.JoinHostPort(host, s.Config.FileConf.TLS.HTTPRedirectPort))
+			if err != nil {
+				closeAll()
+				return nil, err
+			}
+			list = append(list, wl)
+		}
+	}
+
+	return list, nil
+}
+
+// closeListeners closes all listeners and removes unix socket files
+func (s *WebServer) closeListeners() {
+	for _, wl := range s.listeners {
+		wl.ln.Close()
+		if wl.sockPath != "" {
+			os.Remove(wl.sockPath)
+		}
+	}
+}
+
+func (s *WebServer) listenTCP(addr string, tlsConfig *tls.Config) (webListener, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return webListener{}, fmt.Errorf("Cannot listen on %s: %v", addr, err)
+	}
+	scheme := "http"
+	if tlsConfig != nil {
+		ln = tls.NewListener(ln, tlsConfig)
+		scheme = "https"
+	}
+	if strings.HasPrefix(addr, ":") {
+		addr = "localhost" + addr
+	}
+	return webListener{
+		ln:      ln,
+		handler: s.router,
+		desc:    scheme + "://" + addr,
+	}, nil
+}
+
+func (s *WebServer) listenRedirect(addr string) (webListener, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return webListener{}, fmt.Errorf("Cannot listen on %s: %v", addr, err)
+	}
+	if strings.HasPrefix(addr, ":") {
+		addr = "localhost" + addr
+	}
+	return webListener{
+		ln:      ln,
+		handler: s.redirectToHTTPS(),
+		desc:    "http://" + addr + " (redirection to HTTPS)",
+	}, nil
+}
+
+func (s *WebServer) listenUnix(sockPath, mode string) (webListener, error) {
+	perm := os.FileMode(defaultUnixSocketMode)
+	if mode != "" {
+		m, err := strconv.ParseUint(mode, 8, 32)
+		if err != nil {
+			return webListener{}, fmt.Errorf("Invalid unix socket mode %s: %v", mode, err)
+		}
+		perm = os.FileMode(m)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(sockPath), 0700); err != nil {
+		return webListener{}, fmt.Errorf("Cannot create unix socket directory: %v", err)
+	}
+
+	// Remove stale socket file left by a previous instance
+	if fi, err := os.Lstat(sockPath); err == nil {
+		if fi.Mode()&os.ModeSocket == 0 {
+			return webListener{}, fmt.Errorf("Cannot listen on %s: file exists and is not a socket", sockPath)
+		}
+		os.Remove(sockPath)
+	}
+
+	// Socket is created in a private directory and moved to its final path
+	// once its permissions are set, so it's never reachable with default
+	// (umask) permissions
+	tmpDir, err := ioutil.TempDir(filepath.Dir(sockPath), ".xds-sock")
+	if err != nil {
+		return webListener{}, fmt.Errorf("Cannot create unix socket directory: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	tmpPath := filepath.Join(tmpDir, "s")
+
+	ln, err := net.Listen("unix", tmpPath)
+	if err != nil {
+		return webListener{}, fmt.Errorf("Cannot listen on %s: %v", sockPath, err)
+	}
+	if ul, ok := ln.(*net.UnixListener); ok {
+		// socket file is removed by closeListeners
+		ul.SetUnlinkOnClose(false)
+	}
+	if err := os.Chmod(tmpPath, perm); err != nil {
+		ln.Close()
+		return webListener{}, fmt.Errorf("Cannot set permissions of %s: %v", sockPath, err)
+	}
+	if err := os.Rename(tmpPath, sockPath); err != nil {
+		ln.Close()
+		return webListener{}, fmt.Errorf("Cannot listen on %s: %v", sockPath, err)
+	}
+
+	// Mark requests received on unix socket (see isUnixSocketRequest)
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r = r.WithContext(context.WithValue(r.Context(), unixSocketCtxKey, true))
+		s.router.ServeHTTP(w, r)
+	})
+
+	return webListener{
+		ln:       ln,
+		handler:  h,
+		desc:     unixSocketPrefix + sockPath,
+		sockPath: sockPath,
+	}, nil
+}
+
+// isUnixSocketRequest returns true when request has been received on a unix
+// socket (access is then controlled by file system permissions)
+func isUnixSocketRequest(c *gin.Context) bool {
+	v, ok := c.Request.Context().Value(unixSocketCtxKey).(bool)
+	return ok && v
+}
@@ -0,0 +1,189 @@
+/*
+ * Copyright (C) 2017 "IoT.bzh"
+ * Author Sebastien Douheret <sebastien@iot.bzh>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package agent
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	common "github.com/iotbzh/xds-common/golib"
+)
+
+const selfSignedValidity = 365 * 24 * time.Hour
+const selfSignedRenewBefore = 30 * 24 * time.Hour
+
+// isTLSEnabled returns true when web server is serving HTTPS
+func (s *WebServer) isTLSEnabled() bool {
+	return s.Config.FileConf.TLS != nil
+}
+
+// setupTLS checks certificate files and generates a self-signed certificate if needed
+func (s *WebServer) setupTLS() error {
+	tlsCfg := s.Config.FileConf.TLS
+	if tlsCfg.CertFile == "" || tlsCfg.KeyFile == "" {
+		return fmt.Errorf("TLS certFile and keyFile must be both set")
+	}
+
+	if common.Exists(tlsCfg.CertFile) && common.Exists(tlsCfg.KeyFile) {
+		if !tlsCfg.SelfSigned || !certExpiresBefore(tlsCfg.CertFile, time.Now().Add(selfSignedRenewBefore)) {
+			return nil
+		}
+		s.Log.Infof("Self-signed certificate expired or about to expire: %s", tlsCfg.CertFile)
+	} else if !tlsCfg.SelfSigned {
+		return fmt.Errorf("TLS certificate or key file not found (%s, %s)", tlsCfg.CertFile, tlsCfg.KeyFile)
+	}
+
+	s.Log.Infof("Generate self-signed certificate: %s", tlsCfg.CertFile)
+	return generateSelfSignedCert(tlsCfg.CertFile, tlsCfg.KeyFile)
+}
+
+// loadCertificate loads certificate served by HTTPS endpoints
+func (s *WebServer) loadCertificate() error {
+	tlsCfg := s.Config.FileConf.TLS
+	cert, err := tls.LoadX509KeyPair(tlsCfg.CertFile, tlsCfg.KeyFile)
+	if err != nil {
+		return fmt.Errorf("Cannot load TLS certificate: %v", err)
+	}
+	if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+		return fmt.Errorf("Cannot load TLS certificate: %v", err)
+	}
+	s.tlsCert = &cert
+	return nil
+}
+
+// getCertificate returns certificate served by HTTPS endpoints, self-signed
+// certificate is renewed when it is about to expire
+func (s *WebServer) getCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	s.tlsMutex.Lock()
+	defer s.tlsMutex.Unlock()
+
+	if s.Config.FileConf.TLS.SelfSigned && time.Now().Add(selfSignedRenewBefore).After(s.tlsCert.Leaf.NotAfter) {
+		if err := s.setupTLS(); err != nil {
+			s.Log.Errorf("Cannot renew self-signed certificate: %v", err)
+		} else if err := s.loadCertificate(); err != nil {
+			s.Log.Errorf("Cannot renew self-signed certificate: %v", err)
+		}
+	}
+	return s.tlsCert, nil
+}
+
+// certExpiresBefore returns true when certificate file is no longer valid at
+// date t (or cannot be read)
+func certExpiresBefore(file string, t time.Time) bool {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return true
+	}
+	blk, _ := pem.Decode(data)
+	if blk == nil {
+		return true
+	}
+	cert, err := x509.ParseCertificate(blk.Bytes)
+	if err != nil {
+		return true
+	}
+	return t.After(cert.NotAfter)
+}
+
+// redirectToHTTPS returns a handler that redirects all requests to HTTPS port
+func (s *WebServer) redirectToHTTPS() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, err := net.SplitHostPort(r.Host)
+		if err != nil {
+			host = r.Host
+		}
+		url := "https://" + net.JoinHostPort(host, s.httpsPort()) + r.URL.RequestURI()
+		http.Redirect(w, r, url, http.StatusMovedPermanently)
+	})
+}
+
+// generateSelfSignedCert creates a new key and a self-signed certificate valid
+// for localhost and local host name
+func generateSelfSignedCert(certFile, keyFile string) error {
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return err
+	}
+
+	hostname, _ := os.Hostname()
+	dnsNames := []string{"localhost"}
+	if hostname != "" && hostname != "localhost" {
+		dnsNames = append(dnsNames, hostname)
+	}
+
+	tmpl := x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{Organization: []string{"XDS Agent"}, CommonName: dnsNames[len(dnsNames)-1]},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(selfSignedValidity),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		DNSNames:              dnsNames,
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1"), net.ParseIP("::1")},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, &tmpl, &tmpl, &priv.PublicKey, priv)
+	if err != nil {
+		return err
+	}
+	keyDer, err := x509.MarshalECPrivateKey(priv)
+	if err != nil {
+		return err
+	}
+
+	for _, f := range []string{certFile, keyFile} {
+		if err := os.MkdirAll(filepath.Dir(f), 0700); err != nil {
+			return err
+		}
+	}
+
+	fdK, err := os.OpenFile(keyFile, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer fdK.Close()
+	if err := pem.Encode(fdK, &pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}); err != nil {
+		return err
+	}
+
+	fdC, err := os.OpenFile(certFile, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer fdC.Close()
+	return pem.Encode(fdC, &pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
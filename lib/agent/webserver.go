@@ -18,31 +18,44 @@
 package agent
 
 import (
+	"crypto/subtle"
+	"crypto/tls"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"path"
+	"strings"
 
 	"github.com/Sirupsen/logrus"
 	"github.com/gin-contrib/static"
 	"github.com/gin-gonic/gin"
 	"github.com/googollee/go-socket.io"
 	"github.com/iotbzh/xds-agent/lib/xaapiv1"
+	"github.com/syncthing/syncthing/lib/sync"
 )
 
 // WebServer .
 type WebServer struct {
 	*Context
-	router    *gin.Engine
-	api       *APIService
-	sIOServer *socketio.Server
-	webApp    *gin.RouterGroup
-	stop      chan struct{} // signals intentional stop
+	router     *gin.Engine
+	api        *APIService
+	sIOServer  *socketio.Server
+	webApp     *gin.RouterGroup
+	listeners  []webListener
+	tlsCert    *tls.Certificate // served certificate (see getCertificate)
+	tlsMutex   sync.Mutex
+	rateLimits *RateLimits
+	stop       chan struct{} // signals intentional stop
 }
 
 const indexFilename = "index.html"
 
+const apiKeyHeaderName = "X-API-Key"
+const authIdentityKey = "xds-agent-identity"
+const bearerPrefix = "Bearer "
+
 // NewWebServer creates an instance of WebServer
 func NewWebServer(ctx *Context) *WebServer {
 
@@ -62,14 +75,20 @@ func NewWebServer(ctx *Context) *WebServer {
 	r := gin.New()
 
 	svr := &WebServer{
-		Context:   ctx,
-		router:    r,
-		api:       nil,
-		sIOServer: nil,
-		webApp:    nil,
-		stop:      make(chan struct{}),
+		Context:    ctx,
+		router:     r,
+		api:        nil,
+		sIOServer:  nil,
+		webApp:     nil,
+		tlsMutex:   sync.NewMutex(),
+		rateLimits: NewRateLimits(ctx.Config.FileConf.RateLimit),
+		stop:       make(chan struct{}),
 	}
 
+	// Limit rate of requests before sessions middleware (set when sessions
+	// manager is created) to not create sessions for rejected requests
+	r.Use(svr.middlewareRateLimitIP())
+
 	return svr
 }
 
@@ -78,11 +97,7 @@ func (s *WebServer) Serve() error {
 	var err error
 
 	// Setup middlewares
-	s.router.Use(gin.Logger())
-	s.router.Use(gin.Recovery())
-	s.router.Use(s.middlewareCORS())
-	s.router.Use(s.middlewareXDSDetails())
-	s.router.Use(s.middlewareCSRF())
+	s.middlewaresInit()
 
 	// Create REST API
 	s.api = NewAPIV1(s.Context)
@@ -123,12 +138,25 @@ func (s *WebServer) Serve() error {
 		s.webApp.GET("/")
 	}
 
+	// Open listen endpoints
+	if s.isTLSEnabled() {
+		if err := s.setupTLS(); err != nil {
+			return err
+		}
+	}
+	if s.listeners, err = s.createListeners(); err != nil {
+		return err
+	}
+
 	// Serve in the background
-	serveError := make(chan error, 1)
-	go func() {
-		fmt.Printf("Web Server running on localhost:%s ...\n", s.Config.FileConf.HTTPPort)
-		serveError <- http.ListenAndServe(":"+s.Config.FileConf.HTTPPort, s.router)
-	}()
+	// One slot per listener so that no goroutine is blocked once Serve returns
+	serveError := make(chan error, len(s.listeners))
+	for _, wl := range s.listeners {
+		go func(wl webListener) {
+			fmt.Printf("Web Server running on %s ...\n", wl.desc)
+			serveError <- http.Serve(wl.ln, wl.handler)
+		}(wl)
+	}
 
 	fmt.Printf("XDS agent running...\n")
 
@@ -146,10 +174,25 @@ func (s *WebServer) Serve() error {
 	return nil
 }
 
+// middlewaresInit Install middlewares used by all routes (must be called
+// before routes declaration)
+func (s *WebServer) middlewaresInit() {
+	s.router.Use(gin.Logger())
+	s.router.Use(gin.Recovery())
+	s.router.Use(s.middlewareCORS())
+	s.router.Use(s.middlewareXDSDetails())
+	s.router.Use(s.middlewareRateLimit())
+	s.router.Use(s.middlewareAuth())
+	s.router.Use(s.middlewareCSRF())
+}
+
 // Stop web server
 func (s *WebServer) Stop() {
 	s.api.Stop()
+	// Signal stop before closing listeners, so that serve errors caused by
+	// closed listeners are not reported as failures
 	close(s.stop)
+	s.closeListeners()
 }
 
 // serveIndexFile provides initial file (eg. index.html) of webapp
@@ -167,55 +210,173 @@ func (s *WebServer) middlewareXDSDetails() gin.HandlerFunc {
 }
 
 func (s *WebServer) isValidAPIKey(key string) bool {
-	return (s.Config.FileConf.XDSAPIKey != "" && key == s.Config.FileConf.XDSAPIKey)
+	return (s.Config.FileConf.XDSAPIKey != "" &&
+		subtle.ConstantTimeCompare([]byte(key), []byte(s.Config.FileConf.XDSAPIKey)) == 1)
 }
 
-func (s *WebServer) middlewareCSRF() gin.HandlerFunc {
+// authenticate returns the identity associated to a credential (shared API key
+// or issued access token) or nil when credential is not valid
+func (s *WebServer) authenticate(key string) *AuthIdentity {
+	if key == "" {
+		return nil
+	}
+	if s.isValidAPIKey(key) {
+		return &AuthIdentity{Name: "apikey", Scopes: []string{xaapiv1.TokenScopeAdmin}}
+	}
+	if s.tokens != nil {
+		return s.tokens.Authenticate(key)
+	}
+	return nil
+}
+
+// requiredScope returns the scope needed to access a route
+func requiredScope(method, path string) string {
+	if strings.HasPrefix(path, "/socket.io") {
+		return xaapiv1.TokenScopeRead
+	}
+	p := strings.TrimPrefix(path, apiBaseURL)
+	switch {
+	case strings.HasPrefix(p, "/tokens"), strings.HasPrefix(p, "/sessions"):
+		return xaapiv1.TokenScopeAdmin
+	case method == "GET" || method == "HEAD":
+		return xaapiv1.TokenScopeRead
+	case strings.HasPrefix(p, "/exec"), strings.HasPrefix(p, "/signal"):
+		return xaapiv1.TokenScopeExec
+	case strings.HasPrefix(p, "/projects"):
+		return xaapiv1.TokenScopeProjectsWrite
+	case strings.HasPrefix(p, "/events"):
+		return xaapiv1.TokenScopeRead
+	}
+	return xaapiv1.TokenScopeAdmin
+}
+
+// isProtectedPath returns true when path requires an authenticated request
+// (REST API including passthrough routes, and WebSocket)
+func isProtectedPath(path string) bool {
+	return strings.HasPrefix(path, apiBaseURL+"/") || path == apiBaseURL ||
+		strings.HasPrefix(path, "/socket.io")
+}
+
+// isLocalRequest returns true when request comes from localhost
+func isLocalRequest(c *gin.Context) bool {
+	host, _, err := net.SplitHostPort(c.Request.RemoteAddr)
+	if err != nil {
+		host = c.Request.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// getRequestAPIKey returns the credential carried by a request, in order of
+// preference: X-API-Key header, Authorization bearer token and finally
+// apikey query parameter (browsers cannot set headers on WebSocket)
+func getRequestAPIKey(c *gin.Context) string {
+	if key := c.Request.Header.Get(apiKeyHeaderName); key != "" {
+		return key
+	}
+	if auth := c.Request.Header.Get("Authorization"); auth != "" {
+		if len(auth) > len(bearerPrefix) && strings.EqualFold(auth[:len(bearerPrefix)], bearerPrefix) {
+			return strings.TrimSpace(auth[len(bearerPrefix):])
+		}
+	}
+	return c.Query("apikey")
+}
+
+// abortAuth aborts request with an authentication error
+func abortAuth(c *gin.Context, code int, msg string) {
+	if code == http.StatusUnauthorized {
+		c.Header("WWW-Authenticate", `Bearer realm="xds-agent"`)
+	}
+	c.JSON(code, gin.H{"status": "error", "error": msg})
+	c.Abort()
+}
+
+// Authentication middleware
+func (s *WebServer) middlewareAuth() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		// XXX - not used for now
+		// Web application (static files) is always accessible
+		if !isProtectedPath(c.Request.URL.Path) {
+			c.Next()
+			return
+		}
+
+		var ident *AuthIdentity
+		key := getRequestAPIKey(c)
+		if key == "" && isUnixSocketRequest(c) {
+			ident = &AuthIdentity{Name: "unix-socket", Scopes: []string{xaapiv1.TokenScopeAdmin}}
+		} else if key == "" && s.Config.FileConf.Auth.LocalhostBypass && isLocalRequest(c) {
+			ident = &AuthIdentity{Name: "localhost", Scopes: []string{xaapiv1.TokenScopeAdmin}}
+		} else if key == "" {
+			abortAuth(c, http.StatusUnauthorized, "Authentication required")
+			return
+		} else if ident = s.authenticate(key); ident == nil {
+			s.Log.Warningf("Invalid API key from %s (%s %s)", c.Request.RemoteAddr, c.Request.Method, c.Request.URL.Path)
+			abortAuth(c, http.StatusForbidden, "Not valid API key")
+			return
+		}
+
+		scope := requiredScope(c.Request.Method, c.Request.URL.Path)
+		if !ident.HasScope(scope) {
+			abortAuth(c, http.StatusForbidden, "Access denied, scope '"+scope+"' required")
+			return
+		}
+
+		// Save identity in gin metadata and attach it to client session
+		c.Set(authIdentityKey, ident)
+		sid := s.sessions.GetID(c)
+		if sid != "" {
+			s.sessions.SetIdentity(sid, ident)
+		}
+
+		if c.Request.Method != "GET" && c.Request.Method != "HEAD" {
+			s.Log.Infof("AUDIT: user=%s sid=%s %s %s", ident.Name, sid, c.Request.Method, c.Request.URL.Path)
+		}
+
 		c.Next()
-		return
-		/*
-			// Allow requests carrying a valid API key
-			if s.isValidAPIKey(c.Request.Header.Get("X-API-Key")) {
-				// Set the access-control-allow-origin header for CORS requests
-				// since a valid API key has been provided
-				c.Header("Access-Control-Allow-Origin", "*")
-				c.Next()
-				return
-			}
+	}
+}
 
-			// Allow io.socket request
-			if strings.HasPrefix(c.Request.URL.Path, "/socket.io") {
-				c.Next()
-				return
-			}
+// CSRF middleware (double-submit token bound to client session)
+func (s *WebServer) middlewareCSRF() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// Safe methods don't change any state
+		switch c.Request.Method {
+		case "GET", "HEAD", "OPTIONS":
+			c.Next()
+			return
+		}
+
+		// Only REST API is protected, io.socket requests are bound to session
+		if !strings.HasPrefix(c.Request.URL.Path, apiBaseURL) {
+			c.Next()
+			return
+		}
+
+		// Browsers cannot reach unix socket
+		if isUnixSocketRequest(c) {
+			c.Next()
+			return
+		}
+
+		// Allow requests carrying a valid API key or access token (not sent
+		// automatically by browsers)
+		if s.authenticate(getRequestAPIKey(c)) != nil {
+			// Set the access-control-allow-origin header for CORS requests
+			// since a valid API key has been provided
+			c.Header("Access-Control-Allow-Origin", "*")
+			c.Next()
+			return
+		}
 
-			// FIXME Add really CSRF support
-
-			// Allow requests for anything not under the protected path prefix,
-			// and set a CSRF cookie if there isn't already a valid one.
-			//if !strings.HasPrefix(c.Request.URL.Path, prefix) {
-			//	cookie, err := c.Cookie("CSRF-Token-" + unique)
-			//	if err != nil || !validCsrfToken(cookie.Value) {
-			//		s.Log.Debugln("new CSRF cookie in response to request for", c.Request.URL)
-			//		c.SetCookie("CSRF-Token-"+unique, newCsrfToken(), 600, "/", "", false, false)
-			//	}
-			//	c.Next()
-			//	return
-			//}
-
-			// Verify the CSRF token
-			//token := c.Request.Header.Get("X-CSRF-Token-" + unique)
-			//if !validCsrfToken(token) {
-			//	c.AbortWithError(403, "CSRF Error")
-			//	return
-			//}
-
-			//c.Next()
-
-			c.AbortWithError(403, fmt.Errorf("Not valid API key"))
-		*/
+		// Verify the CSRF token
+		token := c.Request.Header.Get(csrfHeaderName)
+		if !s.sessions.IsValidCSRFToken(s.sessions.GetID(c), token) {
+			s.Log.Infof("CSRF check failed for %s %s (from %s)", c.Request.Method, c.Request.URL.Path, c.Request.RemoteAddr)
+			abortAuth(c, http.StatusForbidden, "CSRF token missing or invalid")
+			return
+		}
+
+		c.Next()
 	}
 }
 
@@ -224,8 +385,8 @@ func (s *WebServer) middlewareCORS() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		if c.Request.Method == "OPTIONS" {
 			c.Header("Access-Control-Allow-Origin", "*")
-			c.Header("Access-Control-Allow-Headers", "Content-Type, X-API-Key")
-			c.Header("Access-Control-Allow-Methods", "GET, POST, DELETE")
+			c.Header("Access-Control-Allow-Headers", "Content-Type, Authorization, "+apiKeyHeaderName+", "+csrfHeaderName)
+			c.Header("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE")
 			c.Header("Access-Control-Max-Age", cookieMaxAge)
 			c.AbortWithStatus(204)
 			return
@@ -246,12 +407,14 @@ func (s *WebServer) socketHandler(c *gin.Context) {
 
 	s.sIOServer.On("connection", func(so socketio.Socket) {
 		s.Log.Debugf("WS Connected (WSID=%s, SID=%s)", so.Id(), sess.ID)
-		s.sessions.UpdateIOSocket(sess.ID, &so)
+		var rso socketio.Socket = &rateLimitedSocket{Socket: so, ws: s, sid: sess.ID}
+		s.sessions.UpdateIOSocket(sess.ID, &rso)
 
 		so.On("disconnection", func() {
 			s.Log.Debugf("WS disconnected (WSID=%s, SID=%s)", so.Id(), sess.ID)
 			s.events.UnRegister(xaapiv1.EVTAll, sess.ID)
 			s.sessions.UpdateIOSocket(sess.ID, nil)
+			s.sessions.MarkDirty()
 		})
 	})
 
@@ -0,0 +1,155 @@
+/*
+ * Copyright (C) 2017 "IoT.bzh"
+ * Author Sebastien Douheret <sebastien@iot.bzh>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package agent
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/iotbzh/xds-agent/lib/xdsconfig"
+)
+
+const testAPIKey = "test-apikey"
+
+// newTestRequest returns a request sent from remoteAddr
+func newTestRequest(method, path, remoteAddr string) *http.Request {
+	req, _ := http.NewRequest(method, path, nil)
+	req.RemoteAddr = remoteAddr
+	return req
+}
+
+// newTestJSONRequest returns a request with a JSON body sent by a session
+func newTestJSONRequest(method, path, remoteAddr, sid, body string) *http.Request {
+	req, _ := http.NewRequest(method, path, strings.NewReader(body))
+	req.RemoteAddr = remoteAddr
+	req.Header.Set("Content-Type", "application/json")
+	if sid != "" {
+		req.AddCookie(&http.Cookie{Name: sessionCookieName, Value: sid})
+	}
+	return req
+}
+
+func TestAuthMiddleware(t *testing.T) {
+	ctx, cleanup := newTestRouterContext(t, func(conf *xdsconfig.FileConfig) {
+		conf.XDSAPIKey = testAPIKey
+		conf.Auth.LocalhostBypass = true
+	})
+	defer cleanup()
+
+	remote := "192.0.2.1:4000"
+	local := "127.0.0.1:4000"
+	url := apiBaseURL + "/version"
+
+	tests := []struct {
+		name   string
+		addr   string
+		header string
+		value  string
+		query  string
+		code   int
+	}{
+		{"no credential", remote, "", "", "", http.StatusUnauthorized},
+		{"invalid api key", remote, apiKeyHeaderName, "bad", "", http.StatusForbidden},
+		{"api key header", remote, apiKeyHeaderName, testAPIKey, "", http.StatusOK},
+		{"bearer token", remote, "Authorization", bearerPrefix + testAPIKey, "", http.StatusOK},
+		{"invalid bearer token", remote, "Authorization", bearerPrefix + "bad", "", http.StatusForbidden},
+		{"api key query", remote, "", "", "?apikey=" + testAPIKey, http.StatusOK},
+		{"localhost bypass", local, "", "", "", http.StatusOK},
+		{"localhost with invalid key", local, apiKeyHeaderName, "bad", "", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		req := newTestRequest("GET", url+tt.query, tt.addr)
+		if tt.header != "" {
+			req.Header.Set(tt.header, tt.value)
+		}
+		w := serveRequest(ctx, req)
+		if w.Code != tt.code {
+			t.Errorf("%s: status %d, want %d (%s)", tt.name, w.Code, tt.code, w.Body.String())
+		}
+		if w.Code == http.StatusUnauthorized && w.Header().Get("WWW-Authenticate") == "" {
+			t.Errorf("%s: WWW-Authenticate header not set", tt.name)
+		}
+	}
+
+	// Web application is not protected
+	if w := serveRequest(ctx, newTestRequest("GET", "/index.html", remote)); w.Code == http.StatusUnauthorized {
+		t.Errorf("web application must be reachable without credential")
+	}
+
+	// Localhost is authenticated when bypass is disabled
+	ctx.Config.FileConf.Auth.LocalhostBypass = false
+	if w := serveRequest(ctx, newTestRequest("GET", url, local)); w.Code != http.StatusUnauthorized {
+		t.Errorf("localhost without bypass: status %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+}
+
+func TestCSRFMiddleware(t *testing.T) {
+	ctx, cleanup := newTestRouterContext(t, func(conf *xdsconfig.FileConfig) {
+		conf.XDSAPIKey = testAPIKey
+		conf.Auth.LocalhostBypass = true
+	})
+	defer cleanup()
+
+	local := "127.0.0.1:4000"
+	url := apiBaseURL + "/tokens"
+	body := `{"name": "csrf-test"}`
+
+	// Token is issued with session on GET
+	w := serveRequest(ctx, newTestRequest("GET", apiBaseURL+"/version", local))
+	sid := w.Header().Get(sessionHeaderName)
+	token := w.Header().Get(csrfHeaderName)
+	if w.Code != http.StatusOK || sid == "" || token == "" {
+		t.Fatalf("GET: status %d, session '%s', CSRF token '%s'", w.Code, sid, token)
+	}
+	if !strings.Contains(strings.Join(w.Header()["Set-Cookie"], ";"), csrfCookieName+"="+token) {
+		t.Errorf("CSRF cookie not set: %v", w.Header()["Set-Cookie"])
+	}
+
+	// Token of another session
+	w = serveRequest(ctx, newTestRequest("GET", apiBaseURL+"/version", local))
+	otherToken := w.Header().Get(csrfHeaderName)
+	if otherToken == "" || otherToken == token {
+		t.Fatalf("each session must have its own CSRF token")
+	}
+
+	for _, tt := range []struct {
+		name  string
+		token string
+		code  int
+	}{
+		{"missing token", "", http.StatusForbidden},
+		{"invalid token", "bad", http.StatusForbidden},
+		{"token of another session", otherToken, http.StatusForbidden},
+		{"valid token", token, http.StatusOK},
+	} {
+		req := newTestJSONRequest("POST", url, local, sid, body)
+		if tt.token != "" {
+			req.Header.Set(csrfHeaderName, tt.token)
+		}
+		if w := serveRequest(ctx, req); w.Code != tt.code {
+			t.Errorf("%s: status %d, want %d (%s)", tt.name, w.Code, tt.code, w.Body.String())
+		}
+	}
+
+	// Requests carrying an API key are not sent by browsers
+	req := newTestJSONRequest("POST", url, local, sid, body)
+	req.Header.Set(apiKeyHeaderName, testAPIKey)
+	if w := serveRequest(ctx, req); w.Code != http.StatusOK {
+		t.Errorf("API key without CSRF token: status %d, want %d", w.Code, http.StatusOK)
+	}
+}
@@ -0,0 +1,151 @@
+/*
+ * Copyright (C) 2017 "IoT.bzh"
+ * Author Sebastien Douheret <sebastien@iot.bzh>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package agent
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/iotbzh/xds-agent/lib/xdsconfig"
+)
+
+// serverCreds Credentials and TLS settings used to connect to a XDS Server
+type serverCreds struct {
+	apiKey    string
+	token     string
+	caFile    string
+	certFile  string
+	keyFile   string
+	tlsConfig *tls.Config
+	transport *http.Transport
+	dialer    *websocket.Dialer
+}
+
+func newServerCreds(conf xdsconfig.XDSServerConf) *serverCreds {
+	return &serverCreds{
+		apiKey:   conf.APIKey,
+		token:    conf.Token,
+		caFile:   conf.CAFile,
+		certFile: conf.CertFile,
+		keyFile:  conf.KeyFile,
+	}
+}
+
+// authHeader returns name and value of header used to authenticate agent
+// on XDS Server (empty name when no credential is set)
+func (sc *serverCreds) authHeader() (string, string) {
+	if sc.apiKey != "" {
+		return apiKeyHeaderName, sc.apiKey
+	}
+	if sc.token != "" {
+		return "Authorization", bearerPrefix + sc.token
+	}
+	return "", ""
+}
+
+// loadTLSConfig builds TLS config from CA bundle and client certificate
+// (returns nil when none of them is set)
+func (sc *serverCreds) loadTLSConfig() (*tls.Config, error) {
+	if sc.caFile == "" && sc.certFile == "" && sc.keyFile == "" {
+		return nil, nil
+	}
+
+	cfg := &tls.Config{}
+	if sc.caFile != "" {
+		pem, err := ioutil.ReadFile(sc.caFile)
+		if err != nil {
+			return nil, fmt.Errorf("Cannot read CA file: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("No valid certificate found in CA file %s", sc.caFile)
+		}
+		cfg.RootCAs = pool
+	}
+	if sc.certFile != "" || sc.keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(sc.certFile, sc.keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("Cannot load client certificate: %v", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg, nil
+}
+
+// _SetupTLS Load TLS settings of server and create transport and websocket
+// dialer of this server (each server only uses its own TLS settings)
+func (xs *XdsServer) _SetupTLS() error {
+	cfg, err := xs.creds.loadTLSConfig()
+	if err != nil {
+		return err
+	}
+	if cfg != nil {
+		u, err := url.Parse(xs.BaseURL)
+		if err != nil {
+			return err
+		}
+		if u.Scheme != "https" {
+			xs.Log.Warningf("TLS settings of server %s ignored (url %s is not https)", xs.ID, xs.BaseURL)
+			cfg = nil
+		}
+	}
+
+	// Close connections of previous transport (eg. on reconnection)
+	if xs.creds.transport != nil {
+		xs.creds.transport.CloseIdleConnections()
+	}
+	xs.creds.tlsConfig = cfg
+	xs.creds.transport = &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		TLSClientConfig:     cfg,
+		TLSHandshakeTimeout: 10 * time.Second,
+		IdleConnTimeout:     90 * time.Second,
+	}
+	xs.creds.dialer = &websocket.Dialer{
+		Proxy:            http.ProxyFromEnvironment,
+		TLSClientConfig:  cfg,
+		HandshakeTimeout: 45 * time.Second,
+	}
+	return nil
+}
+
+// _HTTPTransport returns the transport used to send requests to server
+func (xs *XdsServer) _HTTPTransport() http.RoundTripper {
+	if xs.creds.transport == nil {
+		return http.DefaultTransport
+	}
+	return xs.creds.transport
+}
+
+// _CloseTransport Close idle connections of server transport
+func (xs *XdsServer) _CloseTransport() {
+	if xs.creds.transport != nil {
+		xs.creds.transport.CloseIdleConnections()
+	}
+}
@@ -0,0 +1,200 @@
+/*
+ * Copyright (C) 2017 "IoT.bzh"
+ * Author Sebastien Douheret <sebastien@iot.bzh>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package agent
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/syncthing/syncthing/lib/sync"
+)
+
+// serverClient HTTP client used to send REST requests to a XDS Server
+//
+// Requests are sent using transport of server (so only TLS settings of this
+// server are used) and session ID set by server in first reply is sent back
+// in all following requests.
+type serverClient struct {
+	baseURL   string // eg. http://localhost:8000/api/v1
+	client    *http.Client
+	authName  string
+	authValue string
+	sid       string
+	sidMutex  sync.Mutex
+}
+
+// newServerClient Create a client and retrieve session ID from server
+func newServerClient(baseURL string, tr http.RoundTripper, authName, authValue string) (*serverClient, error) {
+	c := &serverClient{
+		baseURL:   strings.TrimRight(baseURL, "/") + "/api/v1",
+		client:    &http.Client{Transport: tr},
+		authName:  authName,
+		authValue: authValue,
+		sidMutex:  sync.NewMutex(),
+	}
+	if err := c.Get("/version", nil); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// connError Error returned when connection to server cannot be established
+// (err is the underlying error)
+type connError struct {
+	msg string
+	err error
+}
+
+func (e *connError) Error() string {
+	return e.msg
+}
+
+// isConnRefused returns true when connection has been refused by server
+func isConnRefused(err error) bool {
+	if cErr, ok := err.(*connError); ok {
+		err = cErr.err
+	}
+	if uErr, ok := err.(*url.Error); ok {
+		err = uErr.Err
+	}
+	opErr, ok := err.(*net.OpError)
+	if !ok || opErr.Op != "dial" {
+		return false
+	}
+	err = opErr.Err
+	if sErr, ok := err.(*os.SyscallError); ok {
+		err = sErr.Err
+	}
+	return err == syscall.ECONNREFUSED
+}
+
+// GetClientID returns session ID set by server
+func (c *serverClient) GetClientID() string {
+	c.sidMutex.Lock()
+	defer c.sidMutex.Unlock()
+	return c.sid
+}
+
+// Get Send a GET request and decode JSON reply into res
+func (c *serverClient) Get(url string, res interface{}) error {
+	return c.do("GET", url, nil, res, 0)
+}
+
+// GetTimeout Send a GET request that is aborted when no reply has been
+// received before timeout
+func (c *serverClient) GetTimeout(url string, res interface{}, timeout time.Duration) error {
+	return c.do("GET", url, nil, res, timeout)
+}
+
+// Post Send a POST request with body encoded in JSON (a string is sent
+// unchanged) and decode JSON reply into res
+func (c *serverClient) Post(url string, body interface{}, res interface{}) error {
+	return c.do("POST", url, body, res, 0)
+}
+
+// Put Send a PUT request with body encoded in JSON and decode JSON reply into res
+func (c *serverClient) Put(url string, body interface{}, res interface{}) error {
+	return c.do("PUT", url, body, res, 0)
+}
+
+// HTTPPost Send a POST request and ignore reply
+func (c *serverClient) HTTPPost(url string, body string) error {
+	return c.do("POST", url, body, nil, 0)
+}
+
+// HTTPDelete Send a DELETE request and ignore reply
+func (c *serverClient) HTTPDelete(url string) error {
+	return c.do("DELETE", url, nil, nil, 0)
+}
+
+func (c *serverClient) do(method, url string, body interface{}, res interface{}, timeout time.Duration) error {
+	var rd io.Reader
+	switch b := body.(type) {
+	case nil:
+	case string:
+		rd = strings.NewReader(b)
+	case []byte:
+		rd = bytes.NewReader(b)
+	default:
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		rd = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest(method, c.baseURL+url, rd)
+	if err != nil {
+		return err
+	}
+	if timeout > 0 {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		req = req.WithContext(ctx)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if sid := c.GetClientID(); sid != "" {
+		req.Header.Set("Xds-Sid", sid)
+	}
+	if c.authName != "" {
+		req.Header.Set(c.authName, c.authValue)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if sid := resp.Header.Get("Xds-Sid"); sid != "" {
+		c.sidMutex.Lock()
+		if c.sid == "" {
+			c.sid = sid
+		}
+		c.sidMutex.Unlock()
+	}
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		// Use error message set by xds-server when possible
+		apiErr := struct {
+			Error string `json:"error"`
+		}{}
+		if json.Unmarshal(data, &apiErr) == nil && apiErr.Error != "" {
+			return fmt.Errorf("%s", apiErr.Error)
+		}
+		return fmt.Errorf("%s %s: %s", method, url, resp.Status)
+	}
+	if res == nil || len(data) == 0 {
+		return nil
+	}
+	return json.Unmarshal(data, res)
+}
@@ -0,0 +1,92 @@
+/*
+ * Copyright (C) 2017 "IoT.bzh"
+ * Author Sebastien Douheret <sebastien@iot.bzh>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package agent
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/iotbzh/xds-agent/lib/xaapiv1"
+	"github.com/iotbzh/xds-server/lib/xsapiv1"
+)
+
+// supportedServerAPIVersions List of XDS Server API versions (major) that
+// agent is able to use (IOW versions compatible with xsapiv1 package)
+var supportedServerAPIVersions = []string{"1"}
+
+// knownSharingTypes Project types (sharing) that agent is able to handle
+var knownSharingTypes = []string{xaapiv1.TypePathMap, xaapiv1.TypeCloudSync}
+
+// checkServerCompat Check that API version and capabilities of a server are
+// compatible with agent, returns the reason of incompatibility
+func checkServerCompat(cfg *xsapiv1.APIConfig) error {
+	if cfg.ServerUID == "" {
+		return fmt.Errorf("server didn't return its ID (not a XDS Server ?)")
+	}
+
+	// Only compare major version (eg. "1" or "1.2")
+	major := strings.SplitN(strings.TrimPrefix(cfg.APIVersion, "v"), ".", 2)[0]
+	found := false
+	for _, v := range supportedServerAPIVersions {
+		if major == v {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return fmt.Errorf("unsupported API version '%s' (server version %s), supported API versions: %s",
+			cfg.APIVersion, cfg.Version, strings.Join(supportedServerAPIVersions, ", "))
+	}
+
+	// At least one sharing type must be usable by agent
+	sharing := []string{}
+	for _, t := range knownSharingTypes {
+		if b, exist := cfg.SupportedSharing[t]; exist && b {
+			sharing = append(sharing, t)
+		}
+	}
+	if len(sharing) == 0 {
+		return fmt.Errorf("server doesn't support any known project type (%s)", strings.Join(knownSharingTypes, ", "))
+	}
+
+	// CloudSync requires Syncthing ID of server builder
+	if b := cfg.SupportedSharing[xaapiv1.TypeCloudSync]; b && cfg.Builder.SyncThingID == "" {
+		return fmt.Errorf("server supports %s but doesn't provide its Syncthing ID", xaapiv1.TypeCloudSync)
+	}
+
+	return nil
+}
+
+// _GetServerCfg Return public state of server
+func (xs *XdsServer) _GetServerCfg() xaapiv1.ServerCfg {
+	return xaapiv1.ServerCfg{
+		ID:                 xs.ID,
+		Name:               xs.Name,
+		Labels:             xs.Labels,
+		URL:                xs.BaseURL,
+		APIURL:             xs.APIURL,
+		PartialURL:         xs.PartialURL,
+		ConnRetry:          xs.ConnRetry,
+		Connected:          xs.Connected,
+		Disabled:           xs.Disabled,
+		Incompatible:       xs.IncompatibleReason != "",
+		IncompatibleReason: xs.IncompatibleReason,
+		Health:             xs.GetHealth(false),
+		Reconnect:          xs.GetReconnectState(),
+	}
+}
@@ -0,0 +1,217 @@
+/*
+ * Copyright (C) 2017 "IoT.bzh"
+ * Author Sebastien Douheret <sebastien@iot.bzh>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package agent
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/iotbzh/xds-agent/lib/xaapiv1"
+	"github.com/syncthing/syncthing/lib/sync"
+)
+
+const defaultHealthPeriod = 30      // Time (in seconds) between 2 health probes
+const defaultDegradedLatency = 1000 // Latency (in ms) above which server is degraded
+const healthHistorySize = 60        // Number of probes kept in history
+
+// serverHealth Health monitoring data of a XDS Server
+type serverHealth struct {
+	mutex           sync.Mutex
+	period          time.Duration
+	degradedLatency time.Duration
+	status          string
+	lastError       string
+	lastCheck       time.Time
+	connectedAt     time.Time
+	reconnectCount  int
+	history         []xaapiv1.HealthSample
+	stop            chan struct{}
+}
+
+func newServerHealth(periodSec, degradedMs int) *serverHealth {
+	if periodSec == 0 {
+		periodSec = defaultHealthPeriod
+	}
+	if degradedMs <= 0 {
+		degradedMs = defaultDegradedLatency
+	}
+	return &serverHealth{
+		mutex:           sync.NewMutex(),
+		period:          time.Duration(periodSec) * time.Second,
+		degradedLatency: time.Duration(degradedMs) * time.Millisecond,
+		status:          xaapiv1.ServerHealthDown,
+	}
+}
+
+// GetHealth returns the health of the server, including probes history when
+// withHistory is set
+func (xs *XdsServer) GetHealth(withHistory bool) xaapiv1.ServerHealth {
+	h := xs.health
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	res := xaapiv1.ServerHealth{
+		Status:         h.status,
+		LastError:      h.lastError,
+		ReconnectCount: h.reconnectCount,
+	}
+	if !h.lastCheck.IsZero() {
+		res.LastCheck = h.lastCheck.Format(time.RFC3339)
+	}
+	if !h.connectedAt.IsZero() {
+		res.ConnectedSince = h.connectedAt.Format(time.RFC3339)
+		res.UptimeSec = int64(time.Since(h.connectedAt).Seconds())
+	}
+
+	var sum, nb int64
+	for _, hs := range h.history {
+		if hs.Error == "" {
+			sum += hs.LatencyMs
+			nb++
+		}
+	}
+	if nb > 0 {
+		res.AvgLatencyMs = sum / nb
+	}
+	if len(h.history) > 0 {
+		res.LatencyMs = h.history[len(h.history)-1].LatencyMs
+	}
+
+	if withHistory {
+		res.History = make([]xaapiv1.HealthSample, len(h.history))
+		copy(res.History, h.history)
+	}
+	return res
+}
+
+// _HealthConnected Update health data when connection is established
+func (xs *XdsServer) _HealthConnected(reConn bool) {
+	h := xs.health
+	h.mutex.Lock()
+	h.connectedAt = time.Now()
+	h.status = xaapiv1.ServerHealthOK
+	h.lastError = ""
+	if reConn {
+		h.reconnectCount++
+	}
+	h.mutex.Unlock()
+
+	xs._HealthMonitorStart()
+}
+
+// _HealthDisconnected Update health data when connection is lost
+func (xs *XdsServer) _HealthDisconnected() {
+	h := xs.health
+	h.mutex.Lock()
+	h.connectedAt = time.Time{}
+	h.status = xaapiv1.ServerHealthDown
+	h.mutex.Unlock()
+}
+
+// _HealthMonitorStart Start periodic health probes (if not already started)
+func (xs *XdsServer) _HealthMonitorStart() {
+	h := xs.health
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if h.period <= 0 || h.stop != nil {
+		return
+	}
+	h.stop = make(chan struct{})
+	go xs._healthMonitor(h.stop)
+}
+
+// _HealthMonitorStop Stop periodic health probes
+func (xs *XdsServer) _HealthMonitorStop() {
+	h := xs.health
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if h.stop != nil {
+		close(h.stop)
+		h.stop = nil
+	}
+}
+
+func (xs *XdsServer) _healthMonitor(stop chan struct{}) {
+	for {
+		select {
+		case <-stop:
+			return
+		case <-time.After(xs.health.period):
+			xs._HealthProbe()
+		}
+	}
+}
+
+// probeTimeout returns the time after which a probe is considered as failed
+// (a probe never lasts more than the period between 2 probes)
+func (h *serverHealth) probeTimeout() time.Duration {
+	if h.period < h.degradedLatency {
+		return h.degradedLatency
+	}
+	return h.period
+}
+
+// _HealthProbe Measure round trip of a /version request and update health status
+func (xs *XdsServer) _HealthProbe() {
+	if xs.client == nil {
+		return
+	}
+
+	var ver interface{}
+	start := time.Now()
+	err := xs.client.GetTimeout("/version", &ver, xs.health.probeTimeout())
+	latency := time.Since(start)
+
+	sample := xaapiv1.HealthSample{
+		Time:      start.Format(time.RFC3339),
+		LatencyMs: int64(latency / time.Millisecond),
+	}
+
+	h := xs.health
+	h.mutex.Lock()
+	prevStatus := h.status
+	h.lastCheck = start
+	if err != nil {
+		sample.Error = err.Error()
+		h.lastError = err.Error()
+	}
+	switch {
+	case !xs.Connected:
+		h.status = xaapiv1.ServerHealthDown
+	case err != nil || latency > h.degradedLatency:
+		h.status = xaapiv1.ServerHealthDegraded
+		if err == nil {
+			h.lastError = fmt.Sprintf("high latency: %v", latency)
+		}
+	default:
+		h.status = xaapiv1.ServerHealthOK
+	}
+	h.history = append(h.history, sample)
+	if len(h.history) > healthHistorySize {
+		h.history = h.history[len(h.history)-healthHistorySize:]
+	}
+	newStatus := h.status
+	h.mutex.Unlock()
+
+	if newStatus != prevStatus {
+		xs.Log.Infof("XDS Server %s health changed: %s -> %s", xs.ID, prevStatus, newStatus)
+		xs._NotifyState()
+	}
+}
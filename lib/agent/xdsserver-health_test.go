@@ -0,0 +1,67 @@
+/*
+ * Copyright (C) 2017 "IoT.bzh"
+ * Author Sebastien Douheret <sebastien@iot.bzh>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package agent
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/iotbzh/xds-agent/lib/xaapiv1"
+	"github.com/iotbzh/xds-agent/lib/xdsconfig"
+	"github.com/syncthing/syncthing/lib/sync"
+)
+
+func TestHealthProbeTimeout(t *testing.T) {
+	ctx, cleanup := newTestContext(t)
+	defer cleanup()
+
+	// Server that accepts connections but never replies
+	hang := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer hang.Close()
+
+	xs := NewXdsServer(ctx, xdsconfig.XDSServerConf{URL: hang.URL, HealthPeriod: 1, DegradedLatency: 100})
+	xs.client = &serverClient{
+		baseURL:  hang.URL + "/api/v1",
+		client:   &http.Client{},
+		sidMutex: sync.NewMutex(),
+	}
+	xs.Connected = true
+	xs.health.status = xaapiv1.ServerHealthOK
+
+	done := make(chan struct{})
+	go func() {
+		xs._HealthProbe()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("health probe blocked by a server that doesn't reply")
+	}
+
+	h := xs.GetHealth(true)
+	if h.Status != xaapiv1.ServerHealthDegraded {
+		t.Errorf("status %s, want %s", h.Status, xaapiv1.ServerHealthDegraded)
+	}
+	if len(h.History) != 1 || h.History[0].Error == "" {
+		t.Errorf("timeout must be recorded as a failed probe: %+v", h.History)
+	}
+}
@@ -0,0 +1,128 @@
+/*
+ * Copyright (C) 2017 "IoT.bzh"
+ * Author Sebastien Douheret <sebastien@iot.bzh>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package agent
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/iotbzh/xds-agent/lib/xaapiv1"
+)
+
+// Server name must start with a letter, so that it cannot be confused with
+// server index used in /servers/<index> routes
+var serverNameRegexp = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9_.-]*$`)
+
+// Names that cannot be used because they are already used by static routes
+// (eg. /servers/discovered) or as special server IDs (eg. auto)
+var serverNameReserved = []string{"discovered", xaapiv1.ServerIDAuto}
+
+// checkServerName returns an error when name cannot be used for a new server
+func (s *APIService) checkServerName(name string) error {
+	if !serverNameRegexp.MatchString(name) {
+		return fmt.Errorf("Invalid server name '%s' (must start with a letter and only contain letters, digits, '_', '.' or '-')", name)
+	}
+	for _, n := range serverNameReserved {
+		if name == n {
+			return fmt.Errorf("Server name '%s' is reserved", name)
+		}
+	}
+	if strings.HasPrefix(name, _IDTempoPrefix) {
+		return fmt.Errorf("Server name cannot start with '%s' (reserved for temporary IDs)", _IDTempoPrefix)
+	}
+	for _, svr := range s.svrList {
+		if svr.Name == name {
+			return fmt.Errorf("Server name '%s' already used", name)
+		}
+	}
+	return nil
+}
+
+// getXdsServer Return server that matches an ID or a name
+func (ctx *Context) getXdsServer(idOrName string) (*XdsServer, bool) {
+	if svr, exist := ctx.xdsServers[idOrName]; exist {
+		return svr, true
+	}
+	if idOrName == "" {
+		return nil, false
+	}
+	for _, svr := range ctx.xdsServers {
+		if svr.Name == idOrName {
+			return svr, true
+		}
+	}
+	return nil, false
+}
+
+// serverRoutesDeclare Declare route used to reach a server (eg. /servers/0 or
+// /servers/<name>) and returns its group. Routes cannot be removed from
+// router, so server is looked up at request time using match and a route
+// already declared for a deleted server is reused when a server with the same
+// name is added again.
+func (s *APIService) serverRoutesDeclare(path string, match func(*XdsServer) bool) *gin.RouterGroup {
+	grp := s.apiRouter.Group(path)
+	if s.svrRoutes[path] {
+		return grp
+	}
+	s.svrRoutes[path] = true
+
+	grp.Any("/*path", func(c *gin.Context) {
+		for _, svr := range s.svrList {
+			if match(svr) {
+				svr.ServePassthrough(c)
+				return
+			}
+		}
+		c.JSON(http.StatusNotFound, gin.H{"status": "error", "error": "Unknown XDS Server"})
+	})
+	return grp
+}
+
+// isListedServer returns true when server has not been deleted
+func (s *APIService) isListedServer(svr *XdsServer) bool {
+	for _, sv := range s.svrList {
+		if sv == svr {
+			return true
+		}
+	}
+	return false
+}
+
+// findListedServer Return listed server (connected or not) that matches an ID
+func (s *APIService) findListedServer(id string) (*XdsServer, bool) {
+	for _, sv := range s.svrList {
+		if sv.ID == id {
+			return sv, true
+		}
+	}
+	return nil, false
+}
+
+// isRegisteredServer returns true when server has been registered in
+// xdsServers (ie. it has been connected at least once or is incompatible)
+func (s *APIService) isRegisteredServer(svr *XdsServer) bool {
+	for _, sv := range s.xdsServers {
+		if sv == svr {
+			return true
+		}
+	}
+	return false
+}
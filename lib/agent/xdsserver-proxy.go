@@ -0,0 +1,143 @@
+/*
+ * Copyright (C) 2017 "IoT.bzh"
+ * Author Sebastien Douheret <sebastien@iot.bzh>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package agent
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	common "github.com/iotbzh/xds-common/golib"
+)
+
+const proxyFlushInterval = 100 * time.Millisecond
+
+// Headers of agent requests that must not be forwarded to XDS Server
+var proxyStripHeaders = []string{
+	apiKeyHeaderName,
+	"Authorization",
+	"Cookie",
+	csrfHeaderName,
+}
+
+// LocalRoute Declare a route of this server that is handled by agent instead
+// of being forwarded to XDS Server (eg. /health)
+func (xs *XdsServer) LocalRoute(method, path string, handler gin.HandlerFunc) {
+	xs.localRoutes[method+" "+path] = handler
+}
+
+// PassthroughInit Create the handler that forwards all requests (any method)
+// to XDS Server, except the ones declared using LocalRoute
+func (xs *XdsServer) PassthroughInit() {
+	xs.proxy = &httputil.ReverseProxy{
+		Director:      xs._proxyDirector,
+		Transport:     &proxyTransport{xs: xs},
+		FlushInterval: proxyFlushInterval,
+		ErrorLog:      log.New(xs.logOut, "XDSSERVER proxy: ", log.LstdFlags),
+	}
+}
+
+// ServePassthrough Handle a request received on a route of this server
+// (eg. /servers/0/*path or /servers/<name>/*path)
+func (xs *XdsServer) ServePassthrough(c *gin.Context) {
+	if h, exist := xs.localRoutes[c.Request.Method+" "+c.Param("path")]; exist {
+		h(c)
+		return
+	}
+	if xs.proxy == nil || xs.Disabled || !xs.Connected || xs.client == nil {
+		common.APIError(c, fmt.Sprintf("XDS Server %s not connected", xs.ID))
+		return
+	}
+	// Normalize path, so that request received on name route is
+	// forwarded as a request received on index route
+	c.Request.URL.Path = xs.APIURL + c.Param("path")
+	xs.proxy.ServeHTTP(c.Writer, c.Request)
+}
+
+// _proxyDirector Rewrite agent request into XDS Server request
+// (eg. /api/v1/servers/0/sdks/123 -> <server_url>/api/v1/sdks/123)
+func (xs *XdsServer) _proxyDirector(req *http.Request) {
+	target, err := url.Parse(xs.BaseURL)
+	if err != nil {
+		xs.Log.Errorf("Invalid XDS Server url %s: %v", xs.BaseURL, err)
+		return
+	}
+
+	req.URL.Scheme = target.Scheme
+	req.URL.Host = target.Host
+	req.URL.Path = strings.TrimRight(target.Path, "/") + "/api/v1" + strings.TrimPrefix(req.URL.Path, xs.APIURL)
+	req.URL.RawPath = ""
+	req.Host = target.Host
+
+	for _, h := range proxyStripHeaders {
+		req.Header.Del(h)
+	}
+	req.Header.Set("Xds-Sid", xs.client.GetClientID())
+	if name, value := xs.creds.authHeader(); name != "" {
+		req.Header.Set(name, value)
+	}
+	if _, exist := req.Header["User-Agent"]; !exist {
+		// explicitly disable User-Agent so it's not set to default value
+		req.Header.Set("User-Agent", "")
+	}
+}
+
+// proxyTransport Used to detect XDS Server disconnection while proxying requests
+type proxyTransport struct {
+	xs *XdsServer
+}
+
+// RoundTrip implements http.RoundTripper interface
+func (t *proxyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	res, err := t.xs._HTTPTransport().RoundTrip(req)
+	if err != nil && isConnError(err) {
+		t.xs.Log.Infof("XDS Server %s unreachable: %v", t.xs.ID, err)
+		t.xs._Disconnected()
+		t.xs._ReconnectStart(false)
+	}
+	return res, err
+}
+
+// isConnError returns true when error means that remote server cannot be reached
+func isConnError(err error) bool {
+	if ue, ok := err.(*url.Error); ok {
+		err = ue.Err
+	}
+	opErr, ok := err.(*net.OpError)
+	if !ok {
+		return false
+	}
+	if opErr.Op == "dial" {
+		return true
+	}
+	if se, ok := opErr.Err.(*os.SyscallError); ok {
+		switch se.Err {
+		case syscall.ECONNREFUSED, syscall.ECONNRESET, syscall.EHOSTUNREACH, syscall.ENETUNREACH:
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,226 @@
+/*
+ * Copyright (C) 2017 "IoT.bzh"
+ * Author Sebastien Douheret <sebastien@iot.bzh>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package agent
+
+import (
+	"math"
+	"math/rand"
+	"time"
+
+	"github.com/iotbzh/xds-agent/lib/xaapiv1"
+	"github.com/iotbzh/xds-agent/lib/xdsconfig"
+	"github.com/syncthing/syncthing/lib/sync"
+)
+
+// Reconnection policy default values
+const (
+	defaultReconnInitialInterval = 1   // in seconds
+	defaultReconnMaxInterval     = 300 // in seconds
+	defaultReconnMultiplier      = 2.0
+	defaultReconnJitter          = 0.2
+)
+
+// reconnectEngine Background reconnection of a XDS Server using exponential
+// backoff (the number of attempts is limited by ConnRetry of server)
+type reconnectEngine struct {
+	mutex      sync.Mutex
+	initial    time.Duration
+	max        time.Duration
+	multiplier float64
+	jitter     float64
+	maxElapsed time.Duration // 0 means never give up
+	running    bool
+	attempt    int
+	nextRetry  time.Time
+	lastError  string
+	trigger    chan struct{}
+}
+
+func newReconnectEngine(cfg xdsconfig.ReconnectConf) *reconnectEngine {
+	r := reconnectEngine{
+		mutex:      sync.NewMutex(),
+		initial:    time.Duration(cfg.InitialInterval) * time.Second,
+		max:        time.Duration(cfg.MaxInterval) * time.Second,
+		multiplier: cfg.Multiplier,
+		jitter:     cfg.Jitter,
+		maxElapsed: time.Duration(cfg.MaxElapsed) * time.Second,
+		trigger:    make(chan struct{}, 1),
+	}
+	if r.initial <= 0 {
+		r.initial = defaultReconnInitialInterval * time.Second
+	}
+	if r.max <= 0 {
+		r.max = defaultReconnMaxInterval * time.Second
+	}
+	if r.max < r.initial {
+		r.max = r.initial
+	}
+	if r.multiplier < 1 {
+		r.multiplier = defaultReconnMultiplier
+	}
+	if r.jitter <= 0 || r.jitter > 1 {
+		r.jitter = defaultReconnJitter
+	}
+	return &r
+}
+
+// backoff returns the next interval to wait (including jitter)
+func (r *reconnectEngine) backoff(attempt int) time.Duration {
+	d := float64(r.initial) * math.Pow(r.multiplier, float64(attempt))
+	if d > float64(r.max) {
+		d = float64(r.max)
+	}
+	d += d * r.jitter * (2*rand.Float64() - 1)
+	return time.Duration(d)
+}
+
+// GetReconnectState returns the state of background reconnection
+func (xs *XdsServer) GetReconnectState() xaapiv1.ReconnectState {
+	r := xs.reconn
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	st := xaapiv1.ReconnectState{
+		Active:    r.running,
+		Attempt:   r.attempt,
+		LastError: r.lastError,
+	}
+	if r.running && !r.nextRetry.IsZero() {
+		st.NextRetry = r.nextRetry.Format(time.RFC3339)
+	}
+	return st
+}
+
+// Reconnect Manually trigger a reconnection attempt
+func (xs *XdsServer) Reconnect() {
+	xs.Disabled = false
+	if xs.Connected {
+		return
+	}
+
+	r := xs.reconn
+	r.mutex.Lock()
+	running := r.running
+	r.mutex.Unlock()
+
+	if running {
+		// Wake up reconnection loop
+		select {
+		case r.trigger <- struct{}{}:
+		default:
+		}
+		return
+	}
+	xs._ReconnectStart(true)
+}
+
+// _ReconnectStart Start reconnection loop in background (if not already running)
+func (xs *XdsServer) _ReconnectStart(immediate bool) {
+	r := xs.reconn
+	r.mutex.Lock()
+	if r.running {
+		r.mutex.Unlock()
+		return
+	}
+	r.running = true
+	r.attempt = 0
+	r.lastError = ""
+	r.mutex.Unlock()
+
+	go xs._reconnectLoop(immediate)
+}
+
+// _ReconnectStop Wake up reconnection loop, so that it exits as soon as
+// server has been disabled
+func (xs *XdsServer) _ReconnectStop() {
+	r := xs.reconn
+	r.mutex.Lock()
+	running := r.running
+	r.mutex.Unlock()
+
+	if running {
+		select {
+		case r.trigger <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (xs *XdsServer) _reconnectLoop(immediate bool) {
+	r := xs.reconn
+	start := time.Now()
+
+	defer func() {
+		r.mutex.Lock()
+		r.running = false
+		r.nextRetry = time.Time{}
+		r.mutex.Unlock()
+		xs._NotifyState()
+	}()
+
+	for !xs.Disabled && !xs.Connected {
+		r.mutex.Lock()
+		wait := r.backoff(r.attempt)
+		if immediate {
+			wait = 0
+			immediate = false
+		}
+		r.nextRetry = time.Now().Add(wait)
+		r.mutex.Unlock()
+
+		select {
+		case <-time.After(wait):
+		case <-r.trigger:
+		}
+		if xs.Disabled || xs.Connected {
+			return
+		}
+
+		r.mutex.Lock()
+		r.attempt++
+		attempt := r.attempt
+		r.mutex.Unlock()
+
+		xs.Log.Infof("Try to reconnect to server %s (%d)", xs.BaseURL, attempt)
+		err := xs._Reconnect()
+
+		r.mutex.Lock()
+		if err != nil {
+			r.lastError = err.Error()
+		} else {
+			r.lastError = ""
+		}
+		r.mutex.Unlock()
+
+		if err == nil {
+			return
+		}
+		if !isConnRefused(err) {
+			xs.Log.Errorf("ERROR while reconnecting: %v", err.Error())
+		}
+
+		// Notify each attempt
+		xs._NotifyState()
+
+		if (r.maxElapsed > 0 && time.Since(start) > r.maxElapsed) ||
+			(xs.ConnRetry > 0 && attempt >= xs.ConnRetry) {
+			xs.Log.Infof("Stop reconnection to server url=%s id=%s !", xs.BaseURL, xs.ID)
+			return
+		}
+	}
+}
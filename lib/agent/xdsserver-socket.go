@@ -0,0 +1,277 @@
+/*
+ * Copyright (C) 2017 "IoT.bzh"
+ * Author Sebastien Douheret <sebastien@iot.bzh>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package agent
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/syncthing/syncthing/lib/sync"
+)
+
+// Engine.io packet types (protocol version 3)
+const (
+	eioOpen    = '0'
+	eioClose   = '1'
+	eioPing    = '2'
+	eioPong    = '3'
+	eioMessage = '4'
+)
+
+// Socket.io packet types
+const (
+	sioConnect    = '0'
+	sioDisconnect = '1'
+	sioEvent      = '2'
+	sioError      = '4'
+)
+
+const sockWriteTimeout = 10 * time.Second
+const sockOpenTimeout = 30 * time.Second
+
+// serverSocket socket.io client used to exchange events with a XDS Server
+//
+// Connection only uses websocket transport and is established with dialer of
+// server, so only TLS settings of this server are used.
+type serverSocket struct {
+	conn         *websocket.Conn
+	pingInterval time.Duration
+	pingTimeout  time.Duration
+	writeMutex   sync.Mutex
+	mutex        sync.Mutex
+	handlers     map[string]func(data interface{})
+	onError      func(err error)
+	onDisconnect func(err error)
+	closed       bool
+	stop         chan struct{}
+}
+
+// newServerSocket Connect socket.io of a XDS Server (baseURL is the url of
+// server, eg. https://localhost:8000)
+func newServerSocket(baseURL string, dialer *websocket.Dialer, header http.Header) (*serverSocket, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "http":
+		u.Scheme = "ws"
+	case "https":
+		u.Scheme = "wss"
+	default:
+		return nil, fmt.Errorf("Invalid url scheme '%s'", u.Scheme)
+	}
+	u.Path = path.Join(u.Path, "/socket.io") + "/"
+	u.RawQuery = "EIO=3&transport=websocket"
+
+	if dialer == nil {
+		dialer = websocket.DefaultDialer
+	}
+	conn, _, err := dialer.Dial(u.String(), header)
+	if err != nil {
+		return nil, err
+	}
+
+	// First packet sent by server gives ping settings
+	conn.SetReadDeadline(time.Now().Add(sockOpenTimeout))
+	_, data, err := conn.ReadMessage()
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if len(data) == 0 || data[0] != eioOpen {
+		conn.Close()
+		return nil, fmt.Errorf("Invalid open packet: %s", data)
+	}
+	open := struct {
+		PingInterval int `json:"pingInterval"`
+		PingTimeout  int `json:"pingTimeout"`
+	}{}
+	if err := json.Unmarshal(data[1:], &open); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("Invalid open packet: %v", err)
+	}
+
+	return &serverSocket{
+		conn:         conn,
+		pingInterval: time.Duration(open.PingInterval) * time.Millisecond,
+		pingTimeout:  time.Duration(open.PingTimeout) * time.Millisecond,
+		writeMutex:   sync.NewMutex(),
+		mutex:        sync.NewMutex(),
+		handlers:     make(map[string]func(data interface{})),
+		stop:         make(chan struct{}),
+	}, nil
+}
+
+// Start Start to read events (handlers of errors and disconnection must be
+// set before)
+func (ss *serverSocket) Start(onError, onDisconnect func(err error)) {
+	ss.onError = onError
+	ss.onDisconnect = onDisconnect
+	go ss.readLoop()
+	if ss.pingInterval > 0 {
+		go ss.pingLoop()
+	}
+}
+
+// On Register handler of an event (data is the first argument of event)
+func (ss *serverSocket) On(evName string, f func(data interface{})) {
+	ss.mutex.Lock()
+	defer ss.mutex.Unlock()
+	ss.handlers[evName] = f
+}
+
+// Emit Send an event to server
+func (ss *serverSocket) Emit(evName string, args ...interface{}) error {
+	data, err := json.Marshal(append([]interface{}{evName}, args...))
+	if err != nil {
+		return err
+	}
+	return ss.write(append([]byte{eioMessage, sioEvent}, data...))
+}
+
+// Close Close connection (disconnection handler is called)
+func (ss *serverSocket) Close() error {
+	ss.mutex.Lock()
+	if ss.closed {
+		ss.mutex.Unlock()
+		return nil
+	}
+	ss.closed = true
+	close(ss.stop)
+	ss.mutex.Unlock()
+
+	ss.write([]byte{eioClose})
+	return ss.conn.Close()
+}
+
+func (ss *serverSocket) write(data []byte) error {
+	ss.writeMutex.Lock()
+	defer ss.writeMutex.Unlock()
+	ss.conn.SetWriteDeadline(time.Now().Add(sockWriteTimeout))
+	return ss.conn.WriteMessage(websocket.TextMessage, data)
+}
+
+// pingLoop Send ping to server, server closes connection when no ping is
+// received before ping timeout
+func (ss *serverSocket) pingLoop() {
+	ticker := time.NewTicker(ss.pingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ss.stop:
+			return
+		case <-ticker.C:
+			if err := ss.write([]byte{eioPing}); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func (ss *serverSocket) readLoop() {
+	var err error
+	defer func() {
+		ss.Close()
+		if ss.onDisconnect != nil {
+			ss.onDisconnect(err)
+		}
+	}()
+
+	for {
+		if ss.pingTimeout > 0 {
+			// Server replies to each ping, so connection is lost when
+			// nothing has been received after ping interval and timeout
+			ss.conn.SetReadDeadline(time.Now().Add(ss.pingInterval + ss.pingTimeout))
+		}
+		var data []byte
+		if _, data, err = ss.conn.ReadMessage(); err != nil {
+			return
+		}
+		if len(data) == 0 {
+			continue
+		}
+		switch data[0] {
+		case eioClose:
+			return
+		case eioPing:
+			ss.write(append([]byte{eioPong}, data[1:]...))
+		case eioMessage:
+			if !ss.onPacket(data[1:]) {
+				return
+			}
+		}
+	}
+}
+
+// onPacket Decode a socket.io packet, returns false on disconnection
+func (ss *serverSocket) onPacket(data []byte) bool {
+	if len(data) == 0 {
+		return true
+	}
+	pktType := data[0]
+	data = data[1:]
+
+	// Skip namespace and ack id (only events of default namespace are used)
+	if len(data) > 0 && data[0] == '/' {
+		if i := bytes.IndexByte(data, ','); i >= 0 {
+			data = data[i+1:]
+		}
+	}
+	for len(data) > 0 && data[0] >= '0' && data[0] <= '9' {
+		data = data[1:]
+	}
+
+	switch pktType {
+	case sioConnect:
+	case sioDisconnect:
+		return false
+	case sioError:
+		if ss.onError != nil {
+			ss.onError(fmt.Errorf("%s", strings.Trim(string(data), "\"")))
+		}
+	case sioEvent:
+		args := []json.RawMessage{}
+		if err := json.Unmarshal(data, &args); err != nil || len(args) == 0 {
+			return true
+		}
+		var evName string
+		if json.Unmarshal(args[0], &evName) != nil {
+			return true
+		}
+		ss.mutex.Lock()
+		f := ss.handlers[evName]
+		ss.mutex.Unlock()
+		if f == nil {
+			return true
+		}
+		var evData interface{}
+		if len(args) > 1 {
+			json.Unmarshal(args[1], &evData)
+		}
+		f(evData)
+	}
+	return true
+}
@@ -0,0 +1,103 @@
+/*
+ * Copyright (C) 2017 "IoT.bzh"
+ * Author Sebastien Douheret <sebastien@iot.bzh>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package agent
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestServerSocketDialer(t *testing.T) {
+	received := make(chan string, 1)
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/socket.io/" || r.URL.Query().Get("transport") != "websocket" {
+			http.Error(w, "invalid transport", http.StatusBadRequest)
+			return
+		}
+		if r.Header.Get("XDS-SID") != "1234" {
+			http.Error(w, "invalid sid", http.StatusBadRequest)
+			return
+		}
+		conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.WriteMessage(websocket.TextMessage, []byte(`0{"sid":"1","upgrades":[],"pingInterval":25000,"pingTimeout":60000}`))
+		conn.WriteMessage(websocket.TextMessage, []byte(`40`))
+		conn.WriteMessage(websocket.TextMessage, []byte(`42["exec:output",{"stdout":"hello"}]`))
+		_, data, err := conn.ReadMessage()
+		if err == nil {
+			received <- string(data)
+		}
+	}))
+	defer srv.Close()
+
+	// Default dialer doesn't trust test server certificate
+	header := http.Header{}
+	header.Set("XDS-SID", "1234")
+	if _, err := newServerSocket(srv.URL, nil, header); err == nil {
+		t.Fatalf("connection must fail without server CA")
+	}
+
+	pool := x509.NewCertPool()
+	pool.AddCert(srv.Certificate())
+	dialer := &websocket.Dialer{TLSClientConfig: &tls.Config{RootCAs: pool}}
+	sock, err := newServerSocket(srv.URL, dialer, header)
+	if err != nil {
+		t.Fatalf("cannot connect socket: %v", err)
+	}
+
+	output := make(chan interface{}, 1)
+	disconnected := make(chan struct{})
+	sock.On("exec:output", func(data interface{}) { output <- data })
+	sock.Start(nil, func(err error) { close(disconnected) })
+
+	select {
+	case data := <-output:
+		if m, ok := data.(map[string]interface{}); !ok || m["stdout"] != "hello" {
+			t.Errorf("invalid event data: %v", data)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("event not received")
+	}
+
+	if err := sock.Emit("exec:input", "ls"); err != nil {
+		t.Fatalf("cannot emit event: %v", err)
+	}
+	select {
+	case data := <-received:
+		if data != `42["exec:input","ls"]` {
+			t.Errorf("invalid emitted packet: %s", data)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("emitted event not received")
+	}
+
+	// Server closes connection after first received packet
+	select {
+	case <-disconnected:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("disconnection not detected")
+	}
+}
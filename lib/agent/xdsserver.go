@@ -18,27 +18,27 @@
 package agent
 
 import (
-	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
+	"net/http/httputil"
 	"strings"
-	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/iotbzh/xds-agent/lib/xaapiv1"
 	"github.com/iotbzh/xds-agent/lib/xdsconfig"
-	common "github.com/iotbzh/xds-common/golib"
 	"github.com/iotbzh/xds-server/lib/xsapiv1"
 	uuid "github.com/satori/go.uuid"
-	sio_client "github.com/sebd71/go-socket.io-client"
+	"github.com/syncthing/syncthing/lib/sync"
 )
 
 // XdsServer .
 type XdsServer struct {
 	*Context
 	ID           string
+	Name         string
+	Labels       map[string]string
 	BaseURL      string
 	APIURL       string
 	PartialURL   string
@@ -47,19 +47,28 @@ type XdsServer struct {
 	Disabled     bool
 	ServerConfig *xsapiv1.APIConfig
 
+	IncompatibleReason string // set when server is not compatible with agent
+
 	// Events management
 	CBOnError      func(error)
 	CBOnDisconnect func(error)
 	sockEvents     map[string][]*caller
-	sockEventsLock *sync.Mutex
+	sockEventsLock sync.Mutex
 
 	// Private fields
-	client      *common.HTTPClient
-	ioSock      *sio_client.Client
+	client      *serverClient
+	ioSock      *serverSocket
 	logOut      io.Writer
 	apiRouter   *gin.RouterGroup
 	cmdList     map[string]interface{}
+	cmdMutex    sync.Mutex
 	cbOnConnect OnConnectedCB
+	health      *serverHealth
+	reconn      *reconnectEngine
+	proxy       *httputil.ReverseProxy
+	creds       *serverCreds
+	conf        xdsconfig.XDSServerConf
+	localRoutes map[string]gin.HandlerFunc
 }
 
 // EventCB Event emitter callback
@@ -83,6 +92,8 @@ func NewXdsServer(ctx *Context, conf xdsconfig.XDSServerConf) *XdsServer {
 	return &XdsServer{
 		Context:    ctx,
 		ID:         _IDTempoPrefix + uuid.NewV1().String(),
+		Name:       conf.Name,
+		Labels:     conf.Labels,
 		BaseURL:    conf.URL,
 		APIURL:     conf.APIBaseURL + conf.APIPartialURL,
 		PartialURL: conf.APIPartialURL,
@@ -91,52 +102,53 @@ func NewXdsServer(ctx *Context, conf xdsconfig.XDSServerConf) *XdsServer {
 		Disabled:   false,
 
 		sockEvents:     make(map[string][]*caller),
-		sockEventsLock: &sync.Mutex{},
+		sockEventsLock: sync.NewMutex(),
 		logOut:         ctx.Log.Out,
 		cmdList:        make(map[string]interface{}),
+		cmdMutex:       sync.NewMutex(),
+		health:         newServerHealth(conf.HealthPeriod, conf.DegradedLatency),
+		reconn:         newReconnectEngine(conf.Reconnect),
+		localRoutes:    make(map[string]gin.HandlerFunc),
+		creds:          newServerCreds(conf),
+		conf:           conf,
 	}
 }
 
+// GetConf Return configuration of server (as set in config file)
+func (xs *XdsServer) GetConf() xdsconfig.XDSServerConf {
+	conf := xs.conf
+	conf.URL = xs.BaseURL
+	conf.ConnRetry = xs.ConnRetry
+	conf.Name = xs.Name
+	conf.Labels = xs.Labels
+	return conf
+}
+
 // Close Free and close XDS Server connection
 func (xs *XdsServer) Close() error {
+	xs._HealthMonitorStop()
 	err := xs._Disconnected()
 	xs.Disabled = true
+	xs._ReconnectStop()
+	xs._CloseTransport()
 	return err
 }
 
 // Connect Establish HTTP connection with XDS Server
 func (xs *XdsServer) Connect() error {
-	var err error
-	var retry int
-
 	xs.Disabled = false
 	xs.Connected = false
 
-	err = nil
-	for retry = xs.ConnRetry; retry > 0; retry-- {
-		if err = xs._CreateConnectHTTP(); err == nil {
-			break
-		}
-		if retry == xs.ConnRetry {
-			// Notify only on the first conn error
-			// doing that avoid 2 notifs (conn false; conn true) on startup
-			xs._NotifyState()
-		}
-		xs.Log.Infof("Establishing connection to XDS Server (retry %d/%d)", retry, xs.ConnRetry)
-		time.Sleep(time.Second)
-	}
-	if retry == 0 {
-		// FIXME: re-use _Reconnect to wait longer in background
-		return fmt.Errorf("Connection to XDS Server failure")
-	}
-	if err != nil {
-		return err
+	if err := xs._CreateConnectHTTP(); err != nil {
+		xs._NotifyState()
+
+		// Keep trying in background using reconnection policy
+		xs._ReconnectStart(false)
+		return fmt.Errorf("Connection to XDS Server failure: %v", err)
 	}
 
 	// Check HTTP connection and establish WS connection
-	err = xs._Connect(false)
-
-	return err
+	return xs._Connect(false)
 }
 
 // ConnectOn Register a callback on events reception
@@ -164,9 +176,13 @@ func (xs *XdsServer) SendCommand(cmd string, body []byte, res interface{}) error
 	return xs.client.Post(url, string(body), res)
 }
 
-// GetVersion Send Get request to retrieve XDS Server version
-func (xs *XdsServer) GetVersion(res interface{}) error {
-	return xs.client.Get("/version", &res)
+// GetVersion Send Get request to retrieve XDS Server version, request is
+// aborted when server doesn't reply before timeout
+func (xs *XdsServer) GetVersion(res interface{}, timeout time.Duration) error {
+	if xs.client == nil {
+		return fmt.Errorf("not connected")
+	}
+	return xs.client.GetTimeout("/version", res, timeout)
 }
 
 // GetFolders Send GET request to get current folder configuration
@@ -213,126 +229,6 @@ func (xs *XdsServer) SetAPIRouterGroup(r *gin.RouterGroup) {
 	xs.apiRouter = r
 }
 
-// PassthroughGet Used to declare a route that sends directly a GET request to XDS Server
-func (xs *XdsServer) PassthroughGet(url string) {
-	if xs.apiRouter == nil {
-		xs.Log.Errorf("apiRouter not set !")
-		return
-	}
-
-	xs.apiRouter.GET(url, func(c *gin.Context) {
-		var data interface{}
-		// Take care of param (eg. id in /projects/:id)
-		nURL := url
-		if strings.Contains(url, ":") {
-			nURL = strings.TrimPrefix(c.Request.URL.Path, xs.APIURL)
-		}
-		// Send Get request
-		if err := xs.client.Get(nURL, &data); err != nil {
-			if strings.Contains(err.Error(), "connection refused") {
-				xs._Disconnected()
-			}
-			common.APIError(c, err.Error())
-			return
-		}
-
-		c.JSON(http.StatusOK, data)
-	})
-}
-
-// PassthroughPost Used to declare a route that sends directly a POST request to XDS Server
-func (xs *XdsServer) PassthroughPost(url string) {
-	if xs.apiRouter == nil {
-		xs.Log.Errorf("apiRouter not set !")
-		return
-	}
-
-	xs.apiRouter.POST(url, func(c *gin.Context) {
-		var err error
-		var data interface{}
-
-		// Get raw body
-		body, err := c.GetRawData()
-		if err != nil {
-			common.APIError(c, err.Error())
-			return
-		}
-
-		// Take care of param (eg. id in /projects/:id)
-		nURL := url
-		if strings.Contains(url, ":") {
-			nURL = strings.TrimPrefix(c.Request.URL.Path, xs.APIURL)
-		}
-
-		// Send Post request
-		response, err := xs.client.HTTPPostWithRes(nURL, string(body))
-		if err != nil {
-			goto httpError
-		}
-		if response.StatusCode != 200 {
-			err = fmt.Errorf(response.Status)
-			return
-		}
-		err = json.Unmarshal(xs.client.ResponseToBArray(response), &data)
-		if err != nil {
-			goto httpError
-		}
-
-		c.JSON(http.StatusOK, data)
-		return
-
-		/* Handle error case */
-	httpError:
-		if strings.Contains(err.Error(), "connection refused") {
-			xs._Disconnected()
-		}
-		common.APIError(c, err.Error())
-	})
-}
-
-// PassthroughDelete Used to declare a route that sends directly a Delete request to XDS Server
-func (xs *XdsServer) PassthroughDelete(url string) {
-	if xs.apiRouter == nil {
-		xs.Log.Errorf("apiRouter not set !")
-		return
-	}
-
-	xs.apiRouter.DELETE(url, func(c *gin.Context) {
-		var err error
-		var data interface{}
-
-		// Take care of param (eg. id in /projects/:id)
-		nURL := url
-		if strings.Contains(url, ":") {
-			nURL = strings.TrimPrefix(c.Request.URL.Path, xs.APIURL)
-		}
-
-		// Send Post request
-		response, err := xs.client.HTTPDeleteWithRes(nURL)
-		if err != nil {
-			goto httpError
-		}
-		if response.StatusCode != 200 {
-			err = fmt.Errorf(response.Status)
-			return
-		}
-		err = json.Unmarshal(xs.client.ResponseToBArray(response), &data)
-		if err != nil {
-			goto httpError
-		}
-
-		c.JSON(http.StatusOK, data)
-		return
-
-		/* Handle error case */
-	httpError:
-		if strings.Contains(err.Error(), "connection refused") {
-			xs._Disconnected()
-		}
-		common.APIError(c, err.Error())
-	})
-}
-
 // EventRegister Post a request to register to an XdsServer event
 func (xs *XdsServer) EventRegister(evName string, filter string) error {
 	return xs.client.Post("/events/register",
@@ -365,7 +261,7 @@ func (xs *XdsServer) EventOn(evName string, privData interface{}, f EventCB) (uu
 		// Register listener only the first time
 		evn := evName
 
-		err := xs.ioSock.On(evn, func(data interface{}) error {
+		xs.ioSock.On(evn, func(data interface{}) {
 			xs.sockEventsLock.Lock()
 			sEvts := make([]*caller, len(xs.sockEvents[evn]))
 			copy(sEvts, xs.sockEvents[evn])
@@ -373,11 +269,7 @@ func (xs *XdsServer) EventOn(evName string, privData interface{}, f EventCB) (uu
 			for _, c := range sEvts {
 				c.Func(c.PrivateData, data)
 			}
-			return nil
 		})
-		if err != nil {
-			return uuid.Nil, err
-		}
 	}
 
 	c := &caller{
@@ -486,7 +378,9 @@ func (xs *XdsServer) FolderToProject(fPrj xsapiv1.FolderConfig) xaapiv1.ProjectC
 
 // CommandAdd Add a new command to the list of running commands
 func (xs *XdsServer) CommandAdd(cmdID string, data interface{}) error {
-	if xs.CommandGet(cmdID) != nil {
+	xs.cmdMutex.Lock()
+	defer xs.cmdMutex.Unlock()
+	if _, exist := xs.cmdList[cmdID]; exist {
 		return fmt.Errorf("command id already exist")
 	}
 	xs.cmdList[cmdID] = data
@@ -495,15 +389,26 @@ func (xs *XdsServer) CommandAdd(cmdID string, data interface{}) error {
 
 // CommandDelete Delete a command from the command list
 func (xs *XdsServer) CommandDelete(cmdID string) error {
-	if xs.CommandGet(cmdID) == nil {
+	xs.cmdMutex.Lock()
+	defer xs.cmdMutex.Unlock()
+	if _, exist := xs.cmdList[cmdID]; !exist {
 		return fmt.Errorf("unknown command id")
 	}
 	delete(xs.cmdList, cmdID)
 	return nil
 }
 
+// CommandCount Return the number of running commands
+func (xs *XdsServer) CommandCount() int {
+	xs.cmdMutex.Lock()
+	defer xs.cmdMutex.Unlock()
+	return len(xs.cmdList)
+}
+
 // CommandGet Retrieve a command data
 func (xs *XdsServer) CommandGet(cmdID string) interface{} {
+	xs.cmdMutex.Lock()
+	defer xs.cmdMutex.Unlock()
 	d, exist := xs.cmdList[cmdID]
 	if exist {
 		return d
@@ -511,6 +416,23 @@ func (xs *XdsServer) CommandGet(cmdID string) interface{} {
 	return nil
 }
 
+// HasSdk Return true when the SDK (ID or name) is installed on the server
+func (xs *XdsServer) HasSdk(sdk string) (bool, error) {
+	if xs.client == nil {
+		return false, fmt.Errorf("not connected")
+	}
+	sdks := []xaapiv1.SDK{}
+	if err := xs.client.Get("/sdks", &sdks); err != nil {
+		return false, err
+	}
+	for _, s := range sdks {
+		if (s.ID == sdk || s.Name == sdk) && s.Status == xaapiv1.SdkStatusInstalled {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
 /***
 ** Private functions
 ***/
@@ -518,27 +440,19 @@ func (xs *XdsServer) CommandGet(cmdID string) interface{} {
 // Create HTTP client
 func (xs *XdsServer) _CreateConnectHTTP() error {
 	var err error
-	xs.client, err = common.HTTPNewClient(xs.BaseURL,
-		common.HTTPClientConfig{
-			URLPrefix:           "/api/v1",
-			HeaderClientKeyName: "Xds-Sid",
-			CsrfDisable:         true,
-			LogOut:              xs.logOut,
-			LogPrefix:           "XDSSERVER: ",
-			LogLevel:            common.HTTPLogLevelWarning,
-		})
 
-	xs.client.SetLogLevel(xs.Log.Level.String())
+	if err = xs._SetupTLS(); err != nil {
+		return fmt.Errorf("ERROR: invalid TLS settings for XDS Server %s: %v", xs.BaseURL, err)
+	}
 
+	authName, authValue := xs.creds.authHeader()
+	xs.client, err = newServerClient(xs.BaseURL, xs._HTTPTransport(), authName, authValue)
 	if err != nil {
 		msg := ": " + err.Error()
-		if strings.Contains(err.Error(), "connection refused") {
+		if isConnRefused(err) {
 			msg = fmt.Sprintf("(url: %s)", xs.BaseURL)
 		}
-		return fmt.Errorf("ERROR: cannot connect to XDS Server %s", msg)
-	}
-	if xs.client == nil {
-		return fmt.Errorf("ERROR: cannot connect to XDS Server (null client)")
+		return &connError{msg: "ERROR: cannot connect to XDS Server " + msg, err: err}
 	}
 
 	return nil
@@ -547,6 +461,13 @@ func (xs *XdsServer) _CreateConnectHTTP() error {
 // _Reconnect Re-established connection
 func (xs *XdsServer) _Reconnect() error {
 
+	// HTTP client may not have been created when initial connection failed
+	if xs.client == nil {
+		if err := xs._CreateConnectHTTP(); err != nil {
+			return err
+		}
+	}
+
 	// Note that ConnectOn callback will be called (see apiv1.go file)
 	err := xs._Connect(true)
 
@@ -565,6 +486,16 @@ func (xs *XdsServer) _Connect(reConn bool) error {
 		return err
 	}
 
+	// Check API version and capabilities
+	if err := checkServerCompat(&xdsCfg); err != nil {
+		xs.IncompatibleReason = err.Error()
+		xs.Connected = false
+		xs.Log.Errorf("XDS Server %s is incompatible: %v", xs.BaseURL, err)
+		xs._NotifyState()
+		return fmt.Errorf("XDS Server %s is incompatible: %v", xs.BaseURL, err)
+	}
+	xs.IncompatibleReason = ""
+
 	if reConn && xs.ID != xdsCfg.ServerUID {
 		xs.Log.Warningf("Reconnected to server but ID differs: old=%s, new=%s", xs.ID, xdsCfg.ServerUID)
 	}
@@ -580,6 +511,7 @@ func (xs *XdsServer) _Connect(reConn bool) error {
 	}
 
 	xs.Connected = true
+	xs._HealthConnected(reConn)
 
 	// Call OnConnect callback
 	if xs.cbOnConnect != nil {
@@ -595,63 +527,39 @@ func (xs *XdsServer) _SocketConnect() error {
 
 	xs.Log.Infof("Connecting IO.socket for server %s (url %s)", xs.ID, xs.BaseURL)
 
-	opts := &sio_client.Options{
-		Transport: "websocket",
-		Header:    make(map[string][]string),
+	header := http.Header{}
+	header.Set("XDS-SID", xs.client.GetClientID())
+	if name, value := xs.creds.authHeader(); name != "" {
+		header.Set(name, value)
 	}
-	opts.Header["XDS-SID"] = []string{xs.client.GetClientID()}
 
-	iosk, err := sio_client.NewClient(xs.BaseURL, opts)
+	iosk, err := newServerSocket(xs.BaseURL, xs.creds.dialer, header)
 	if err != nil {
 		return fmt.Errorf("IO.socket connection error for server %s: %v", xs.ID, err)
 	}
 	xs.ioSock = iosk
 
-	// Register some listeners
-
-	iosk.On("error", func(err error) {
+	// Register some listeners and start events reception
+	iosk.Start(func(err error) {
 		xs.Log.Infof("IO.socket Error server %s; err: %v", xs.ID, err)
 		if xs.CBOnError != nil {
 			xs.CBOnError(err)
 		}
-	})
-
-	iosk.On("disconnection", func(err error) {
+	}, func(err error) {
+		// Socket closed on purpose (see _Disconnected)
+		if xs.ioSock != iosk {
+			return
+		}
 		xs.Log.Infof("IO.socket disconnection server %s", xs.ID)
 		if xs.CBOnDisconnect != nil {
 			xs.CBOnDisconnect(err)
 		}
 		xs._Disconnected()
 
-		// Try to reconnect during 15min (or at least while not disabled)
-		go func() {
-			count := 0
-			waitTime := 1
-			for !xs.Disabled && !xs.Connected {
-				count++
-				if count%60 == 0 {
-					waitTime *= 5
-				}
-				if waitTime > 15*60 {
-					xs.Log.Infof("Stop reconnection to server url=%s id=%s !", xs.BaseURL, xs.ID)
-					return
-				}
-				time.Sleep(time.Second * time.Duration(waitTime))
-				xs.Log.Infof("Try to reconnect to server %s (%d)", xs.BaseURL, count)
-
-				err := xs._Reconnect()
-				if err != nil &&
-					!(strings.Contains(err.Error(), "dial tcp") && strings.Contains(err.Error(), "connection refused")) {
-					xs.Log.Errorf("ERROR while reconnecting: %v", err.Error())
-				}
-
-			}
-		}()
+		// Try to reconnect in background (see reconnection policy)
+		xs._ReconnectStart(false)
 	})
 
-	// XXX - There is no connection event generated so, just consider that
-	// we are connected when NewClient return successfully
-	/* iosk.On("connection", func() { ... }) */
 	xs.Log.Infof("IO.socket connected server url=%s id=%s", xs.BaseURL, xs.ID)
 
 	return nil
@@ -664,7 +572,11 @@ func (xs *XdsServer) _Disconnected() error {
 		delete(xs.sockEvents, k)
 	}
 	xs.Connected = false
-	xs.ioSock = nil
+	if iosk := xs.ioSock; iosk != nil {
+		xs.ioSock = nil
+		iosk.Close()
+	}
+	xs._HealthDisconnected()
 	xs._NotifyState()
 	return nil
 }
@@ -672,14 +584,7 @@ func (xs *XdsServer) _Disconnected() error {
 // _NotifyState Send event to notify changes
 func (xs *XdsServer) _NotifyState() {
 
-	evSts := xaapiv1.ServerCfg{
-		ID:         xs.ID,
-		URL:        xs.BaseURL,
-		APIURL:     xs.APIURL,
-		PartialURL: xs.PartialURL,
-		ConnRetry:  xs.ConnRetry,
-		Connected:  xs.Connected,
-	}
+	evSts := xs._GetServerCfg()
 	if err := xs.events.Emit(xaapiv1.EVTServerConfig, evSts, ""); err != nil {
 		xs.Log.Warningf("Cannot notify XdsServer state change: %v", err)
 	}
@@ -0,0 +1,158 @@
+/*
+ * Copyright (C) 2017 "IoT.bzh"
+ * Author Sebastien Douheret <sebastien@iot.bzh>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package discovery implements a simple UDP broadcast protocol used to find
+// XDS Servers on the local network.
+//
+// A client broadcasts a probe message and each server answers (unicast) with
+// a JSON announcement that describes how to reach it.
+package discovery
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+)
+
+// DefaultPort UDP port used by discovery protocol
+const DefaultPort = 8009
+
+// ProbeMessage Message broadcasted by clients to discover servers
+const ProbeMessage = "XDS-DISCOVER/1"
+
+const maxMessageSize = 4096
+
+// Announce Message sent by a server in response to a probe
+type Announce struct {
+	Name             string          `json:"name"`
+	URL              string          `json:"url"`
+	Version          string          `json:"version"`
+	APIVersion       string          `json:"apiVersion"`
+	SupportedSharing map[string]bool `json:"supportedSharing"`
+}
+
+// Reply Announce received from a server
+type Reply struct {
+	Announce
+	From string // address of the server that replies
+}
+
+// Probe Broadcast a probe on port and collect replies until timeout
+// (loopback address is also probed so that local servers are found)
+func Probe(port int, timeout time.Duration) ([]Reply, error) {
+	if port <= 0 {
+		port = DefaultPort
+	}
+
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{})
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	targets := []string{"255.255.255.255", "127.0.0.1"}
+	sent := 0
+	for _, ip := range targets {
+		addr := &net.UDPAddr{IP: net.ParseIP(ip), Port: port}
+		if _, err := conn.WriteToUDP([]byte(ProbeMessage), addr); err == nil {
+			sent++
+		}
+	}
+	if sent == 0 {
+		return nil, fmt.Errorf("cannot send discovery probe on port %d", port)
+	}
+
+	replies := []Reply{}
+	known := make(map[string]bool)
+	buf := make([]byte, maxMessageSize)
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	for {
+		n, from, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			if nerr, ok := err.(net.Error); ok && nerr.Timeout() {
+				break
+			}
+			return replies, err
+		}
+		ann := Announce{}
+		if err := json.Unmarshal(buf[:n], &ann); err != nil || ann.URL == "" {
+			// Ignore invalid replies
+			continue
+		}
+		// A server may reply several times (eg. broadcast + loopback)
+		if known[ann.URL] {
+			continue
+		}
+		known[ann.URL] = true
+		replies = append(replies, Reply{Announce: ann, From: from.String()})
+	}
+
+	return replies, nil
+}
+
+// Responder Answer to discovery probes (used by servers or as test double)
+type Responder struct {
+	conn     *net.UDPConn
+	announce Announce
+}
+
+// NewResponder Listen for probes on addr (eg. ":8009" or "127.0.0.1:8009")
+func NewResponder(addr string, ann Announce) (*Responder, error) {
+	if addr == "" {
+		addr = ":" + strconv.Itoa(DefaultPort)
+	}
+	uAddr, err := net.ResolveUDPAddr("udp4", addr)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenUDP("udp4", uAddr)
+	if err != nil {
+		return nil, err
+	}
+	return &Responder{conn: conn, announce: ann}, nil
+}
+
+// Addr returns local address of responder
+func (r *Responder) Addr() net.Addr {
+	return r.conn.LocalAddr()
+}
+
+// Serve Answer to probes until responder is closed
+func (r *Responder) Serve() error {
+	data, err := json.Marshal(r.announce)
+	if err != nil {
+		return err
+	}
+	buf := make([]byte, maxMessageSize)
+	for {
+		n, from, err := r.conn.ReadFromUDP(buf)
+		if err != nil {
+			return err
+		}
+		if string(buf[:n]) != ProbeMessage {
+			continue
+		}
+		r.conn.WriteToUDP(data, from)
+	}
+}
+
+// Close Stop responder
+func (r *Responder) Close() error {
+	return r.conn.Close()
+}
@@ -0,0 +1,54 @@
+/*
+ * Copyright (C) 2017 "IoT.bzh"
+ * Author Sebastien Douheret <sebastien@iot.bzh>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package discovery
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestProbeLoopback(t *testing.T) {
+	ann := Announce{
+		Name:             "lab",
+		URL:              "http://127.0.0.1:8000",
+		Version:          "1.0.0",
+		APIVersion:       "1",
+		SupportedSharing: map[string]bool{"PathMap": true},
+	}
+	r, err := NewResponder("127.0.0.1:0", ann)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	go r.Serve()
+
+	replies, err := Probe(r.Addr().(*net.UDPAddr).Port, 500*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(replies) != 1 {
+		t.Fatalf("got %d replies, want 1", len(replies))
+	}
+	rep := replies[0]
+	if rep.Name != ann.Name || rep.URL != ann.URL || rep.Version != ann.Version || !rep.SupportedSharing["PathMap"] {
+		t.Errorf("got announce %+v, want %+v", rep.Announce, ann)
+	}
+	if host, _, _ := net.SplitHostPort(rep.From); host != "127.0.0.1" {
+		t.Errorf("reply from %s, want 127.0.0.1", rep.From)
+	}
+}
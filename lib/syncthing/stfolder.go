@@ -141,17 +141,16 @@ func (s *SyncThing) FolderChange(f FolderChangeArg) (string, error) {
 	})
 
 	found = false
-	var fld stconfig.FolderConfiguration
-	for _, fld = range stCfg.Folders {
+	for i, fld := range stCfg.Folders {
 		if folder.ID == fld.ID {
-			fld = folder
+			// Replace existing folder (eg. to share it with another device)
+			stCfg.Folders[i] = folder
 			found = true
 			break
 		}
 	}
 	if !found {
 		stCfg.Folders = append(stCfg.Folders, folder)
-		fld = stCfg.Folders[0]
 	}
 
 	err = s.ConfigSet(stCfg)
@@ -29,11 +29,72 @@ type APIConfig struct {
 
 // ServerCfg .
 type ServerCfg struct {
-	ID         string `json:"id"`
-	URL        string `json:"url"`
-	APIURL     string `json:"apiUrl"`
-	PartialURL string `json:"partialUrl"`
-	ConnRetry  int    `json:"connRetry"`
-	Connected  bool   `json:"connected"`
-	Disabled   bool   `json:"disabled"`
+	ID         string            `json:"id"`
+	Name       string            `json:"name,omitempty"`
+	Labels     map[string]string `json:"labels,omitempty"`
+	URL        string            `json:"url"`
+	APIURL     string            `json:"apiUrl"`
+	PartialURL string            `json:"partialUrl"`
+	ConnRetry  int               `json:"connRetry"`
+	Connected  bool              `json:"connected"`
+	Disabled   bool              `json:"disabled"`
+
+	Incompatible       bool   `json:"incompatible"` // server API not compatible with agent
+	IncompatibleReason string `json:"incompatibleReason,omitempty"`
+
+	Health    ServerHealth   `json:"health"`
+	Reconnect ReconnectState `json:"reconnect"`
+}
+
+// ReconnectState State of background reconnection to a XDS Server
+type ReconnectState struct {
+	Active    bool   `json:"active"`
+	Attempt   int    `json:"attempt"`
+	NextRetry string `json:"nextRetry,omitempty"`
+	LastError string `json:"lastError,omitempty"`
+}
+
+// Server health status definition
+const (
+	ServerHealthOK       = "ok"
+	ServerHealthDegraded = "degraded"
+	ServerHealthDown     = "down"
+)
+
+// ServerHealth Health of a XDS Server, updated by periodic probes
+type ServerHealth struct {
+	Status         string         `json:"status"`
+	LatencyMs      int64          `json:"latencyMs"`      // latency of last probe
+	AvgLatencyMs   int64          `json:"avgLatencyMs"`   // average latency of successful probes in history
+	LastError      string         `json:"lastError"`      // error of last failed probe
+	LastCheck      string         `json:"lastCheck"`      // time of last probe
+	ConnectedSince string         `json:"connectedSince"` // empty when not connected
+	UptimeSec      int64          `json:"uptimeSec"`
+	ReconnectCount int            `json:"reconnectCount"`
+	History        []HealthSample `json:"history,omitempty"`
+}
+
+// HealthSample Result of one health probe
+type HealthSample struct {
+	Time      string `json:"time"`
+	LatencyMs int64  `json:"latencyMs"`
+	Error     string `json:"error,omitempty"`
+}
+
+// DiscoveredServer XDS Server found on local network (see GET /servers/discovered)
+type DiscoveredServer struct {
+	Name             string          `json:"name"`
+	URL              string          `json:"url"`
+	Version          string          `json:"version"`
+	APIVersion       string          `json:"apiVersion"`
+	SupportedSharing map[string]bool `json:"supportedSharing"`
+	Address          string          `json:"address"` // address that answered to discovery probe
+	LastSeen         string          `json:"lastSeen"`
+	Adopted          bool            `json:"adopted"` // true when server is already used by agent
+}
+
+// ServerAdoptArgs JSON parameters of POST /servers/discovered/adopt command
+type ServerAdoptArgs struct {
+	URL  string `json:"url"`  // URL of discovered server
+	Name string `json:"name"` // or name of discovered server
 }
@@ -45,6 +45,7 @@ const (
 	EVTProjectAdd    = EventTypePrefix + "project-add"          // type EventMsg with Data type xaapiv1.ProjectConfig
 	EVTProjectDelete = EventTypePrefix + "project-delete"       // type EventMsg with Data type xaapiv1.ProjectConfig
 	EVTProjectChange = EventTypePrefix + "project-state-change" // type EventMsg with Data type xaapiv1.ProjectConfig
+	EVTProjectOpDone = EventTypePrefix + "project-op-done"      // type EventMsg with Data type xaapiv1.ProjectOpResult
 	EVTSDKInstall    = EventTypePrefix + "sdk-install"          // type EventMsg with Data type xaapiv1.SDKManagementMsg
 	EVTSDKRemove     = EventTypePrefix + "sdk-remove"           // type EventMsg with Data type xaapiv1.SDKManagementMsg
 )
@@ -55,6 +56,7 @@ var EVTAllList = []string{
 	EVTProjectAdd,
 	EVTProjectDelete,
 	EVTProjectChange,
+	EVTProjectOpDone,
 	EVTSDKInstall,
 	EVTSDKRemove,
 }
@@ -63,6 +65,7 @@ var EVTAllList = []string{
 type EventMsg struct {
 	Time          string      `json:"time"`      // Timestamp
 	FromSessionID string      `json:"sessionID"` // Session ID of client who produce this event
+	FromUser      string      `json:"user"`      // Identity name of client who produce this event
 	Type          string      `json:"type"`      // Data type
 	Data          interface{} `json:"data"`      // Data
 }
@@ -97,6 +100,19 @@ func (e *EventMsg) DecodeProjectConfig() (ProjectConfig, error) {
 	return p, err
 }
 
+// DecodeProjectOpResult Helper to decode Data field type ProjectOpResult
+func (e *EventMsg) DecodeProjectOpResult() (ProjectOpResult, error) {
+	r := ProjectOpResult{}
+	if e.Type != EVTProjectOpDone {
+		return r, fmt.Errorf("Invalid type")
+	}
+	d, err := json.Marshal(e.Data)
+	if err == nil {
+		err = json.Unmarshal(d, &r)
+	}
+	return r, err
+}
+
 // DecodeSDKMsg Helper to decode Data field type SDKManagementMsg
 func (e *EventMsg) DecodeSDKMsg() (SDKManagementMsg, error) {
 	var err error
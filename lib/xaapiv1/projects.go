@@ -33,12 +33,17 @@ const (
 	StatusEnable      = "Enable"
 	StatusPause       = "Pause"
 	StatusSyncing     = "Syncing"
+	StatusOffline     = "Offline" // XDS Server of project is not reachable
+	StatusPending     = "Pending" // operations on project wait for XDS Server connection
 )
 
+// ServerIDAuto Special server ID used to let agent select the XDS Server
+const ServerIDAuto = "auto"
+
 // ProjectConfig is the config for one project
 type ProjectConfig struct {
 	ID         string      `json:"id"`
-	ServerID   string      `json:"serverId"`
+	ServerID   string      `json:"serverId"` // use ServerIDAuto to let agent choose the server
 	Label      string      `json:"label"`
 	ClientPath string      `json:"clientPath"`
 	ServerPath string      `json:"serverPath"`
@@ -46,10 +51,111 @@ type ProjectConfig struct {
 	Status     string      `json:"status"`
 	IsInSync   bool        `json:"isInSync"`
 	DefaultSdk string      `json:"defaultSdk"`
-	ClientData string      `json:"clientData"` // free form field that can used by client
+	ClientData string      `json:"clientData"`          // free form field that can used by client
+	ConfFiles  []string    `json:"confFiles,omitempty"` // settings files maintained by agent in ClientPath (see ProjectFileXXX)
+}
+
+// Settings files that agent can maintain in ClientPath of a project
+const (
+	ProjectFileConf  = "conf"  // xds-project.conf (shell exports)
+	ProjectFileEnv   = "env"   // xds-project.env (dotenv format)
+	ProjectFileJSON  = "json"  // xds-project.json (JSON descriptor)
+	ProjectFileCMake = "cmake" // CMakeUserPresets.json (CMake presets)
+)
+
+// ProjectFilesArgs JSON parameters of POST /projects/:id/files command
+type ProjectFilesArgs struct {
+	Files    []string `json:"files"`              // list of ProjectFileXXX, empty list to not manage any file
+	AgentURL string   `json:"agentUrl,omitempty"` // URL used by clients to reach agent (default derived from listen settings)
 }
 
 // ProjectConfigUpdatableFields List fields that can be updated using Update function
 var ProjectConfigUpdatableFields = []string{
 	"Label", "DefaultSdk", "ClientData",
 }
+
+// ProjectMigrateArgs JSON parameters of POST /projects/:id/migrate command
+type ProjectMigrateArgs struct {
+	ServerID   string `json:"serverId" binding:"required"` // target server ID (or ServerIDAuto)
+	ServerPath string `json:"serverPath"`                  // new server path (PathMap projects only)
+	Timeout    int    `json:"timeout"`                     // max time (in seconds) to wait first sync (default 120)
+}
+
+// ProjectBundle Portable definition of a project, used to move a project
+// between agents (see GET /projects/:id/export and POST /projects/import)
+type ProjectBundle struct {
+	Version        int         `json:"version"`
+	Label          string      `json:"label"`
+	Type           ProjectType `json:"type"`
+	DefaultSdk     string      `json:"defaultSdk"`
+	ClientData     string      `json:"clientData"`
+	ClientPath     string      `json:"clientPath"`           // relative to client root (absolute when outside of root)
+	ServerPath     string      `json:"serverPath"`           // relative to server root (absolute when outside of root)
+	ServerName     string      `json:"serverName,omitempty"` // name of server that holds project (hint for import)
+	Env            []string    `json:"env,omitempty"`        // additional variables exported in xds-project.conf (NAME=VALUE)
+	IgnorePatterns []string    `json:"ignorePatterns,omitempty"`
+}
+
+// ProjectImportArgs JSON parameters of POST /projects/import command
+type ProjectImportArgs struct {
+	Bundle     ProjectBundle     `json:"bundle" binding:"required"`
+	ServerID   string            `json:"serverId"`   // target server ID or name (default: server of bundle if known, else ServerIDAuto)
+	ClientRoot string            `json:"clientRoot"` // root of relative client path (default: agent config)
+	ServerRoot string            `json:"serverRoot"` // root of relative server path (default: agent config)
+	PathMap    map[string]string `json:"pathMap"`    // paths prefixes remapping, applied on bundle paths before roots
+}
+
+// Actions proposed for a discovered project
+const (
+	DiscoverActionNone     = "none"     // project already registered
+	DiscoverActionRegister = "register" // unknown project, can be registered as a new project
+	DiscoverActionRelink   = "relink"   // project known by a server but for another client path
+	DiscoverActionOffline  = "offline"  // project hold by a server currently offline (cannot be applied)
+)
+
+// ProjectDiscoverArgs JSON parameters of POST /projects/discover command
+type ProjectDiscoverArgs struct {
+	Root     string      `json:"root" binding:"required"` // directory to scan
+	MaxDepth int         `json:"maxDepth"`                // max depth of scan (default 5)
+	Apply    []string    `json:"apply"`                   // client paths of projects to register or re-link (none: only report)
+	ServerID string      `json:"serverId"`                // server of registered projects (default ServerIDAuto)
+	Type     ProjectType `json:"type"`                    // type of registered projects (default CloudSync)
+}
+
+// DiscoveredProject Project found from a xds-project.conf file
+type DiscoveredProject struct {
+	ConfFile   string         `json:"confFile"`
+	ClientPath string         `json:"clientPath"`
+	ProjectID  string         `json:"projectId"`         // XDS_PROJECT_ID set in file
+	SdkID      string         `json:"sdkId"`             // XDS_SDK_ID set in file
+	AgentURL   string         `json:"agentUrl"`          // XDS_AGENT_URL set in file
+	Action     string         `json:"action"`            // DiscoverActionXXX
+	Project    *ProjectConfig `json:"project,omitempty"` // known project, or new project when action has been applied
+	Applied    bool           `json:"applied"`
+	Error      string         `json:"error,omitempty"`
+}
+
+// Project deferred operations definition
+const (
+	ProjectOpAdd    = "add"
+	ProjectOpUpdate = "update"
+	ProjectOpDelete = "delete"
+)
+
+// Project deferred operation results definition
+const (
+	ProjectOpDone     = "done"
+	ProjectOpFailed   = "failed"
+	ProjectOpConflict = "conflict" // project changed on server side meanwhile (eg. deleted)
+)
+
+// ProjectOpResult Result of an operation that has been deferred while XDS
+// Server of project was offline (Data of EVTProjectOpDone event)
+type ProjectOpResult struct {
+	OpID      string        `json:"opId"`
+	Op        string        `json:"op"` // ProjectOpAdd, ProjectOpUpdate or ProjectOpDelete
+	ProjectID string        `json:"projectId"`
+	Status    string        `json:"status"` // ProjectOpDone, ProjectOpFailed or ProjectOpConflict
+	Error     string        `json:"error,omitempty"`
+	Project   ProjectConfig `json:"project"`
+}
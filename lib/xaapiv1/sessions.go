@@ -0,0 +1,31 @@
+/*
+ * Copyright (C) 2017 "IoT.bzh"
+ * Author Sebastien Douheret <sebastien@iot.bzh>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package xaapiv1
+
+// SessionInfo Description of a client session (result of GET /sessions)
+type SessionInfo struct {
+	ID         string   `json:"id"`
+	WSID       string   `json:"wsId"`
+	User       string   `json:"user"`
+	RemoteAddr string   `json:"remoteAddr"`
+	UserAgent  string   `json:"userAgent"`
+	CreatedAt  string   `json:"createdAt"`
+	ExpireAt   string   `json:"expireAt"`
+	Events     []string `json:"events"`   // registered events
+	Commands   []string `json:"commands"` // IDs of running commands
+}
@@ -0,0 +1,42 @@
+/*
+ * Copyright (C) 2017 "IoT.bzh"
+ * Author Sebastien Douheret <sebastien@iot.bzh>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package xaapiv1
+
+// TemplateParam Parameter of a project template
+type TemplateParam struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Default     string `json:"default"`
+	Required    bool   `json:"required"`
+}
+
+// ProjectTemplate Template used to create files of a new project
+// (see GET /templates)
+type ProjectTemplate struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description"`
+	BuiltIn     bool            `json:"builtIn"`
+	Params      []TemplateParam `json:"params"`
+}
+
+// ProjectAddArgs JSON parameters of POST /projects command
+type ProjectAddArgs struct {
+	ProjectConfig
+	Template string            `json:"template"` // when set, project files are created from this template
+	Params   map[string]string `json:"params"`   // template parameters
+}
@@ -0,0 +1,57 @@
+/*
+ * Copyright (C) 2017 "IoT.bzh"
+ * Author Sebastien Douheret <sebastien@iot.bzh>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package xaapiv1
+
+// Access token scopes definition
+const (
+	TokenScopeRead          = "read"           // read-only access (GET requests, events)
+	TokenScopeExec          = "exec"           // execute commands and send signals
+	TokenScopeProjectsWrite = "projects:write" // add, update and delete projects
+	TokenScopeAdmin         = "admin"          // full access (config, tokens, sdks management)
+)
+
+// TokenScopesList List of all supported scopes
+var TokenScopesList = []string{
+	TokenScopeRead,
+	TokenScopeExec,
+	TokenScopeProjectsWrite,
+	TokenScopeAdmin,
+}
+
+// TokenCreateArgs JSON parameters of POST /tokens command
+type TokenCreateArgs struct {
+	Name      string   `json:"name" binding:"required"`
+	Scopes    []string `json:"scopes"`
+	ExpiresIn int      `json:"expiresIn"` // validity in seconds (0 = never expire)
+}
+
+// TokenInfo Public description of an access token
+type TokenInfo struct {
+	ID        string   `json:"id"`
+	Name      string   `json:"name"`
+	Scopes    []string `json:"scopes"`
+	CreatedAt string   `json:"createdAt"`
+	ExpiresAt string   `json:"expiresAt"` // empty when token never expires
+}
+
+// TokenCreateResult JSON result of POST /tokens command
+// (token value is only returned once, on creation)
+type TokenCreateResult struct {
+	TokenInfo
+	Token string `json:"token"`
+}
@@ -40,6 +40,8 @@ type Config struct {
 	FileConf      FileConfig
 	Log           *logrus.Logger
 	LogVerboseOut io.Writer
+
+	fileServers []XDSServerConf // servers of config file (see state.go)
 }
 
 // Options set at the command line
@@ -61,6 +63,14 @@ func Init(ctx *cli.Context, log *logrus.Logger) (*Config, error) {
 
 	defaultWebAppDir := "${EXEPATH}/www"
 	defaultSTHomeDir := "${HOME}/.xds/agent/syncthing-config"
+	defaultTokensFile := "${HOME}/.xds/agent/tokens.json"
+	defaultStateFile := "${HOME}/.xds/agent/agent-state.json"
+	defaultProjectsCacheFile := "${HOME}/.xds/agent/projects-cache.json"
+	defaultProjectsJournalFile := "${HOME}/.xds/agent/projects-journal.json"
+	defaultTemplatesDir := "${HOME}/.xds/agent/templates"
+	defaultProjectsFilesStateFile := "${HOME}/.xds/agent/projects-files.json"
+	defaultTLSCertFile := "${HOME}/.xds/agent/tls/agent-cert.pem"
+	defaultTLSKeyFile := "${HOME}/.xds/agent/tls/agent-key.pem"
 
 	// TODO: allocate uuid only the first time and save+reuse it later
 	uuid := uuid.NewV1().String()
@@ -82,6 +92,32 @@ func Init(ctx *cli.Context, log *logrus.Logger) (*Config, error) {
 			HTTPPort:  "8800",
 			WebAppDir: defaultWebAppDir,
 			LogsDir:   "/tmp/logs",
+			StateFile: defaultStateFile,
+			ProjectsConf: ProjectsConf{
+				CacheFile:       defaultProjectsCacheFile,
+				JournalFile:     defaultProjectsJournalFile,
+				ClientRoot:      "${HOME}",
+				TemplatesDir:    defaultTemplatesDir,
+				ConfFiles:       []string{"conf"},
+				FilesStateFile:  defaultProjectsFilesStateFile,
+				ServerSelection: "leastLoaded",
+			},
+			Auth: AuthConf{
+				LocalhostBypass: true,
+				TokensFile:      defaultTokensFile,
+			},
+			RateLimit: RateLimitConf{
+				IPRate:             50,
+				IPBurst:            100,
+				SessionRate:        20,
+				SessionBurst:       50,
+				ExpensiveRate:      1,
+				ExpensiveBurst:     10,
+				EventRate:          200,
+				EventBurst:         500,
+				SessionCreateRate:  5,
+				SessionCreateBurst: 50,
+			},
 			ServersConf: []XDSServerConf{
 				XDSServerConf{
 					URL:       "http://localhost:8000",
@@ -103,6 +139,23 @@ func Init(ctx *cli.Context, log *logrus.Logger) (*Config, error) {
 		return nil, err
 	}
 
+	// runtime changes saved in state file overwrite config file settings
+	if err := c.loadState(); err != nil {
+		return nil, err
+	}
+
+	// Use a self-signed certificate stored in agent home when HTTPS is
+	// enabled without certificate
+	if c.FileConf.TLS != nil && c.FileConf.TLS.CertFile == "" && c.FileConf.TLS.KeyFile == "" {
+		if c.FileConf.TLS.CertFile, err = common.ResolveEnvVar(defaultTLSCertFile); err != nil {
+			return nil, err
+		}
+		if c.FileConf.TLS.KeyFile, err = common.ResolveEnvVar(defaultTLSKeyFile); err != nil {
+			return nil, err
+		}
+		c.FileConf.TLS.SelfSigned = true
+	}
+
 	// Handle where Logs are redirected:
 	//  default 'stdout' (logfile option default value)
 	//  else use file (or filepath) set by --logfile option
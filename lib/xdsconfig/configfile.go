@@ -36,19 +36,120 @@ type XDSServerConf struct {
 	URL       string `json:"url"`
 	ConnRetry int    `json:"connRetry"`
 
+	Name   string            `json:"name"`   // stable name, usable in routes instead of index (/servers/<name>)
+	Labels map[string]string `json:"labels"` // free labels (eg. {"site": "lab1"})
+
+	HealthPeriod    int `json:"healthPeriod"`    // health probe period in seconds (default 30, -1 to disable)
+	DegradedLatency int `json:"degradedLatency"` // latency in ms above which server is considered as degraded (default 1000)
+
+	Reconnect ReconnectConf `json:"reconnect"`
+
+	// Credentials and TLS settings used to connect to server
+	APIKey   string `json:"apiKey"`   // sent in X-API-Key header
+	Token    string `json:"token"`    // sent as bearer token in Authorization header
+	CAFile   string `json:"caFile"`   // CA bundle used to verify server certificate (https/wss)
+	CertFile string `json:"certFile"` // client certificate (optional)
+	KeyFile  string `json:"keyFile"`  // client certificate key (optional)
+
 	// private/not exported fields
 	ID            string `json:"-"`
+	Origin        string `json:"-"` // key of config file entry (empty when added at runtime)
+	Restored      bool   `json:"-"` // added at runtime and restored from state file
 	APIBaseURL    string `json:"-"`
 	APIPartialURL string `json:"-"`
 }
 
+// ReconnectConf Reconnection policy of a XDS Server (exponential backoff),
+// maximum number of attempts is set by connRetry (0 means no limit)
+type ReconnectConf struct {
+	InitialInterval int     `json:"initialInterval"` // first interval in seconds (default 1)
+	MaxInterval     int     `json:"maxInterval"`     // maximum interval in seconds (default 300)
+	Multiplier      float64 `json:"multiplier"`      // interval multiplier after each attempt (default 2)
+	Jitter          float64 `json:"jitter"`          // randomization factor of interval (default 0.2)
+	MaxElapsed      int     `json:"maxElapsed"`      // give up after this time in seconds (default 0, never give up)
+}
+
+// AuthConf Authentication settings of REST API and WebSocket
+type AuthConf struct {
+	LocalhostBypass bool   `json:"localhostBypass"` // requests from localhost are not authenticated
+	TokensFile      string `json:"tokensFile"`      // file used to store issued access tokens
+}
+
+// TLSConf HTTPS settings (a self-signed certificate is generated when
+// certFile and keyFile are not set)
+type TLSConf struct {
+	CertFile         string `json:"certFile"`
+	KeyFile          string `json:"keyFile"`
+	HTTPRedirectPort string `json:"httpRedirectPort"` // when set, HTTP requests on this port are redirected to HTTPS
+
+	// private/not exported fields
+	SelfSigned bool `json:"-"`
+}
+
+// ListenConf Web server listen endpoint
+type ListenConf struct {
+	Address string `json:"address"` // "IP:port" (eg. 127.0.0.1:8800) or "unix:" followed by socket path
+	Mode    string `json:"mode"`    // unix socket file permissions in octal (default 0600)
+}
+
+// SessionsConf Client sessions settings
+type SessionsConf struct {
+	PersistFile string `json:"persistFile"` // when set, sessions are saved in this file and restored on restart
+	MaxSessions int    `json:"maxSessions"` // maximum number of sessions (0 = default)
+}
+
+// RateLimitConf Requests rate limiting settings (token buckets, rate in
+// requests per second, a rate set to 0 disables the limit)
+type RateLimitConf struct {
+	IPRate             float64 `json:"ipRate"` // REST calls per client IP
+	IPBurst            int     `json:"ipBurst"`
+	SessionRate        float64 `json:"sessionRate"` // REST calls per session
+	SessionBurst       int     `json:"sessionBurst"`
+	ExpensiveRate      float64 `json:"expensiveRate"` // /exec and POST /projects per session
+	ExpensiveBurst     int     `json:"expensiveBurst"`
+	EventRate          float64 `json:"eventRate"` // socket events per session
+	EventBurst         int     `json:"eventBurst"`
+	SessionCreateRate  float64 `json:"sessionCreateRate"` // new sessions per client IP
+	SessionCreateBurst int     `json:"sessionCreateBurst"`
+}
+
+// DiscoveryConf Settings of XDS Servers discovery on local network
+type DiscoveryConf struct {
+	Disable bool `json:"disable"`
+	Port    int  `json:"port"`    // UDP port (default 8009)
+	Timeout int  `json:"timeout"` // time to wait replies in ms (default 1000)
+}
+
+// ProjectsConf Projects settings
+type ProjectsConf struct {
+	CacheFile    string `json:"cacheFile"`    // last known projects config, used while servers are unreachable
+	DeferOffline bool   `json:"deferOffline"` // queue add/update/delete of projects while their server is offline
+	JournalFile  string `json:"journalFile"`  // file used to save queued operations
+	ClientRoot   string `json:"clientRoot"`   // paths of exported projects are relative to these roots
+	ServerRoot   string `json:"serverRoot"`
+	TemplatesDir string `json:"templatesDir"` // user defined projects templates
+
+	ServerSelection string `json:"serverSelection"` // policy used to choose server of projects added with serverId "auto" (leastLoaded or first)
+
+	ConfFiles      []string `json:"confFiles"`      // settings files maintained by default in ClientPath (conf, env, json, cmake)
+	FilesStateFile string   `json:"filesStateFile"` // file used to save settings files managed for each project
+}
+
 type FileConfig struct {
-	HTTPPort    string          `json:"httpPort"`
-	WebAppDir   string          `json:"webAppDir"`
-	LogsDir     string          `json:"logsDir"`
-	XDSAPIKey   string          `json:"xds-apikey"`
-	ServersConf []XDSServerConf `json:"xdsServers"`
-	SThgConf    *SyncThingConf  `json:"syncthing"`
+	HTTPPort     string          `json:"httpPort"`
+	Listen       []ListenConf    `json:"listen"` // when set, httpPort is ignored
+	WebAppDir    string          `json:"webAppDir"`
+	LogsDir      string          `json:"logsDir"`
+	StateFile    string          `json:"stateFile"` // runtime changes (eg. servers list) are saved in this file
+	XDSAPIKey    string          `json:"xds-apikey"`
+	Auth         AuthConf        `json:"auth"`
+	TLS          *TLSConf        `json:"tls"`
+	SessionsConf SessionsConf    `json:"sessions"`
+	RateLimit    RateLimitConf   `json:"rateLimit"`
+	ServersConf  []XDSServerConf `json:"xdsServers"`
+	Discovery    DiscoveryConf   `json:"discovery"`
+	ProjectsConf ProjectsConf    `json:"projects"`
+	SThgConf     *SyncThingConf  `json:"syncthing"`
 }
 
 // readGlobalConfig reads configuration from a config file.
@@ -117,6 +218,27 @@ resVars:
 	vars := []*string{
 		&c.FileConf.LogsDir,
 		&c.FileConf.WebAppDir,
+		&c.FileConf.Auth.TokensFile,
+		&c.FileConf.SessionsConf.PersistFile,
+		&c.FileConf.StateFile,
+		&c.FileConf.ProjectsConf.CacheFile,
+		&c.FileConf.ProjectsConf.JournalFile,
+		&c.FileConf.ProjectsConf.ClientRoot,
+		&c.FileConf.ProjectsConf.ServerRoot,
+		&c.FileConf.ProjectsConf.TemplatesDir,
+		&c.FileConf.ProjectsConf.FilesStateFile,
+	}
+	for i := range c.FileConf.Listen {
+		vars = append(vars, &c.FileConf.Listen[i].Address)
+	}
+	for i := range c.FileConf.ServersConf {
+		svr := &c.FileConf.ServersConf[i]
+		vars = append(vars, &svr.APIKey, &svr.Token,
+			&svr.CAFile, &svr.CertFile, &svr.KeyFile)
+	}
+	if c.FileConf.TLS != nil {
+		vars = append(vars, &c.FileConf.TLS.CertFile,
+			&c.FileConf.TLS.KeyFile)
 	}
 	if c.FileConf.SThgConf != nil {
 		vars = append(vars, &c.FileConf.SThgConf.Home,
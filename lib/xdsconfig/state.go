@@ -0,0 +1,168 @@
+/*
+ * Copyright (C) 2017 "IoT.bzh"
+ * Author Sebastien Douheret <sebastien@iot.bzh>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package xdsconfig
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+
+	common "github.com/iotbzh/xds-common/golib"
+)
+
+// StateConf Agent state modified at runtime (eg. by POST /config) and saved
+// in state file. State is a layer on top of config file: servers of config
+// file are identified by their name (or url when name is not set) and only
+// runtime changes are saved, so later edits of config file are still used.
+// Credentials of servers added at runtime are saved too, so state file is
+// only readable by its owner.
+type StateConf struct {
+	AddedServers   []XDSServerConf         `json:"addedServers,omitempty"`
+	ChangedServers map[string]ServerChange `json:"changedServers,omitempty"`
+	DeletedServers []string                `json:"deletedServers,omitempty"`
+}
+
+// ServerChange Settings of a config file server changed at runtime
+type ServerChange struct {
+	URL       string            `json:"url"`
+	ConnRetry int               `json:"connRetry"`
+	Labels    map[string]string `json:"labels,omitempty"`
+}
+
+// serverKey returns key used to identify a server of config file
+func serverKey(svr XDSServerConf) string {
+	if svr.Name != "" {
+		return svr.Name
+	}
+	return svr.URL
+}
+
+// loadState reads state file (if any) and applies runtime changes on top of
+// config file settings
+func (c *Config) loadState() error {
+	c.fileServers = make([]XDSServerConf, len(c.FileConf.ServersConf))
+	for i := range c.FileConf.ServersConf {
+		c.FileConf.ServersConf[i].Origin = serverKey(c.FileConf.ServersConf[i])
+		c.fileServers[i] = c.FileConf.ServersConf[i]
+	}
+
+	file := c.FileConf.StateFile
+	if file == "" || !common.Exists(file) {
+		return nil
+	}
+
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	state := StateConf{}
+	if err := json.Unmarshal(data, &state); err != nil {
+		return fmt.Errorf("Invalid state file %s: %v", file, err)
+	}
+
+	c.Log.Infof("Use state file: %s", file)
+
+	servers := []XDSServerConf{}
+	deleted := make(map[string]bool)
+	for _, key := range state.DeletedServers {
+		deleted[key] = true
+	}
+	known := make(map[string]bool)
+	for _, svr := range c.fileServers {
+		known[svr.Origin] = true
+		if deleted[svr.Origin] {
+			c.Log.Infof("XDS Server %s of config file deleted at runtime (see state file)", svr.Origin)
+			continue
+		}
+		if chg, exist := state.ChangedServers[svr.Origin]; exist {
+			c.Log.Infof("XDS Server %s of config file changed at runtime (see state file)", svr.Origin)
+			svr.URL = chg.URL
+			svr.ConnRetry = chg.ConnRetry
+			svr.Labels = chg.Labels
+		}
+		servers = append(servers, svr)
+	}
+	for key := range state.ChangedServers {
+		if !known[key] {
+			c.Log.Warningf("XDS Server %s changed at runtime is no longer in config file, change ignored", key)
+		}
+	}
+	for _, svr := range state.AddedServers {
+		c.Log.Infof("XDS Server %s added at runtime (see state file)", serverKey(svr))
+		svr.Origin = ""
+		svr.Restored = true
+		servers = append(servers, svr)
+	}
+	c.FileConf.ServersConf = servers
+	return nil
+}
+
+// SaveServers writes runtime changes of XDS Servers list into state file
+func (c *Config) SaveServers(servers []XDSServerConf) error {
+	c.FileConf.ServersConf = servers
+
+	file := c.FileConf.StateFile
+	if file == "" {
+		return nil
+	}
+
+	state := StateConf{ChangedServers: make(map[string]ServerChange)}
+	present := make(map[string]bool)
+	for _, svr := range servers {
+		if svr.Origin == "" {
+			state.AddedServers = append(state.AddedServers, svr)
+			continue
+		}
+		present[svr.Origin] = true
+		for _, fSvr := range c.fileServers {
+			if fSvr.Origin == svr.Origin && (fSvr.URL != svr.URL ||
+				fSvr.ConnRetry != svr.ConnRetry || !reflect.DeepEqual(fSvr.Labels, svr.Labels)) {
+				state.ChangedServers[svr.Origin] = ServerChange{
+					URL:       svr.URL,
+					ConnRetry: svr.ConnRetry,
+					Labels:    svr.Labels,
+				}
+			}
+		}
+	}
+	for _, fSvr := range c.fileServers {
+		if !present[fSvr.Origin] {
+			state.DeletedServers = append(state.DeletedServers, fSvr.Origin)
+		}
+	}
+
+	data, err := json.MarshalIndent(state, "", "    ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0700); err != nil {
+		return fmt.Errorf("Cannot create state directory: %v", err)
+	}
+	tmpFile := file + ".tmp"
+	if err := ioutil.WriteFile(tmpFile, data, 0600); err != nil {
+		return fmt.Errorf("Cannot save state: %v", err)
+	}
+	// Permissions are not changed by WriteFile when file already exists
+	if err := os.Chmod(tmpFile, 0600); err != nil {
+		return fmt.Errorf("Cannot save state: %v", err)
+	}
+	return os.Rename(tmpFile, file)
+}
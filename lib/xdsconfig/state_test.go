@@ -0,0 +1,111 @@
+/*
+ * Copyright (C) 2017 "IoT.bzh"
+ * Author Sebastien Douheret <sebastien@iot.bzh>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package xdsconfig
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Sirupsen/logrus"
+)
+
+func newTestConfig(stateFile string, servers []XDSServerConf) *Config {
+	log := logrus.New()
+	log.Out = ioutil.Discard
+	c := &Config{Log: log}
+	c.FileConf.StateFile = stateFile
+	c.FileConf.ServersConf = servers
+	return c
+}
+
+func TestStateLayer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "xds-agent-state")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "agent-state.json")
+
+	fileServers := []XDSServerConf{
+		{Name: "build", URL: "http://build:8000", ConnRetry: 10, APIKey: "secret1"},
+		{URL: "http://other:8000", ConnRetry: 10},
+	}
+	c := newTestConfig(file, fileServers)
+	if err := c.loadState(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Runtime: change url of first server, delete second one and add a new one
+	build := c.FileConf.ServersConf[0]
+	build.URL = "http://build2:8000"
+	added := XDSServerConf{URL: "http://new:8000", ConnRetry: 1, Token: "secret2"}
+	if err := c.SaveServers([]XDSServerConf{build, added}); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	state := StateConf{}
+	if err := json.Unmarshal(data, &state); err != nil {
+		t.Fatal(err)
+	}
+	if len(state.AddedServers) != 1 || state.AddedServers[0].Token != "secret2" {
+		t.Errorf("added servers must be saved with their credentials: %+v", state.AddedServers)
+	}
+	if fi, err := os.Stat(file); err != nil {
+		t.Error(err)
+	} else if fi.Mode().Perm() != 0600 {
+		t.Errorf("state file must only be readable by its owner: %v", fi.Mode())
+	}
+	if strings.Contains(string(data), "secret1") {
+		t.Errorf("credentials of config file servers must not be saved")
+	}
+	if chg, exist := state.ChangedServers["build"]; !exist || chg.URL != "http://build2:8000" {
+		t.Errorf("changed server not saved: %+v", state.ChangedServers)
+	}
+	if len(state.DeletedServers) != 1 || state.DeletedServers[0] != "http://other:8000" {
+		t.Errorf("deleted server not saved: %+v", state.DeletedServers)
+	}
+
+	// Restart with an edited config file: edits of config file are kept
+	fileServers[0].APIKey = "secret3"
+	fileServers = append(fileServers, XDSServerConf{URL: "http://third:8000"})
+	c = newTestConfig(file, fileServers)
+	if err := c.loadState(); err != nil {
+		t.Fatal(err)
+	}
+	svrs := c.FileConf.ServersConf
+	if len(svrs) != 3 {
+		t.Fatalf("got %d servers, want 3: %+v", len(svrs), svrs)
+	}
+	if svrs[0].URL != "http://build2:8000" || svrs[0].APIKey != "secret3" {
+		t.Errorf("runtime change not applied on config file server: %+v", svrs[0])
+	}
+	if svrs[1].URL != "http://third:8000" {
+		t.Errorf("server added in config file not used: %+v", svrs[1])
+	}
+	if svrs[2].URL != "http://new:8000" || svrs[2].Origin != "" ||
+		!svrs[2].Restored || svrs[2].Token != "secret2" {
+		t.Errorf("server added at runtime not restored: %+v", svrs[2])
+	}
+}
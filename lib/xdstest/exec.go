@@ -0,0 +1,178 @@
+/*
+ * Copyright (C) 2017 "IoT.bzh"
+ * Author Sebastien Douheret <sebastien@iot.bzh>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package xdstest
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/iotbzh/xds-server/lib/xsapiv1"
+	uuid "github.com/satori/go.uuid"
+)
+
+// Output One chunk of command output
+type Output struct {
+	Stdout string
+	Stderr string
+}
+
+// CommandScript Describe how the fake server executes a command
+type CommandScript struct {
+	Output     []Output      // output chunks, sent in order
+	Delay      time.Duration // delay before each output chunk and before exit
+	ExitCode   int           // exit code of command
+	WaitSignal bool          // when true, command only exits when a signal is received
+}
+
+// runningCmd Command being executed
+type runningCmd struct {
+	args   xsapiv1.ExecArgs
+	signal chan string
+}
+
+// SetCommand Define script executed when cmd (first word of command line) is
+// received by /exec
+func (s *Server) SetCommand(cmd string, script CommandScript) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.scripts[cmd] = script
+}
+
+// SetDefaultCommand Define script executed for commands without specific
+// script (by default, command line is echoed on stdout and exit code is 0)
+func (s *Server) SetDefaultCommand(script CommandScript) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.defScr = &script
+}
+
+// Running Return IDs of commands still running
+func (s *Server) Running() []string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	res := []string{}
+	for id := range s.cmds {
+		res = append(res, id)
+	}
+	return res
+}
+
+// execCmd Handle POST /exec
+func (s *Server) execCmd(w http.ResponseWriter, r *http.Request) {
+	args := xsapiv1.ExecArgs{}
+	if err := json.NewDecoder(r.Body).Decode(&args); err != nil || strings.TrimSpace(args.Cmd) == "" {
+		writeError(w, "Invalid arguments")
+		return
+	}
+	if args.CmdID == "" {
+		args.CmdID = uuid.NewV1().String()
+	}
+
+	s.mutex.Lock()
+	if _, exist := s.folders[args.ID]; !exist && args.ID != "" {
+		s.mutex.Unlock()
+		writeError(w, "Unknown id")
+		return
+	}
+	cmdLine := strings.TrimSpace(args.Cmd + " " + strings.Join(args.Args, " "))
+	script, exist := s.scripts[strings.Fields(args.Cmd)[0]]
+	if !exist {
+		if s.defScr != nil {
+			script = *s.defScr
+		} else {
+			script = CommandScript{Output: []Output{{Stdout: cmdLine + "\n"}}}
+		}
+	}
+	cmd := &runningCmd{args: args, signal: make(chan string, 1)}
+	s.cmds[args.CmdID] = cmd
+	s.execs = append(s.execs, args)
+	s.mutex.Unlock()
+
+	writeJSON(w, xsapiv1.ExecResult{Status: "OK", CmdID: args.CmdID})
+
+	go s.runCmd(r.Header.Get(sidHeaderName), cmd, script)
+}
+
+// runCmd Play command script and send output and exit events
+func (s *Server) runCmd(sid string, cmd *runningCmd, script CommandScript) {
+	cmdID := cmd.args.CmdID
+	code := script.ExitCode
+
+	// Let client receive /exec reply before events
+	time.Sleep(10 * time.Millisecond)
+
+	for _, out := range script.Output {
+		if script.Delay > 0 {
+			select {
+			case <-time.After(script.Delay):
+			case <-cmd.signal:
+				goto exit
+			}
+		}
+		s.emitTo(sid, xsapiv1.ExecOutEvent, xsapiv1.ExecOutMsg{
+			CmdID:     cmdID,
+			Timestamp: time.Now().String(),
+			Stdout:    out.Stdout,
+			Stderr:    out.Stderr,
+		})
+	}
+
+	if script.WaitSignal {
+		<-cmd.signal
+	} else if script.Delay > 0 {
+		time.Sleep(script.Delay)
+	}
+
+exit:
+	s.mutex.Lock()
+	delete(s.cmds, cmdID)
+	s.mutex.Unlock()
+
+	s.emitTo(sid, xsapiv1.ExecExitEvent, xsapiv1.ExecExitMsg{
+		CmdID:     cmdID,
+		Timestamp: time.Now().String(),
+		Code:      code,
+	})
+}
+
+// signalCmd Handle POST /signal
+func (s *Server) signalCmd(w http.ResponseWriter, r *http.Request) {
+	args := xsapiv1.ExecSignalArgs{}
+	if err := json.NewDecoder(r.Body).Decode(&args); err != nil || args.CmdID == "" {
+		writeError(w, "Invalid arguments")
+		return
+	}
+
+	s.mutex.Lock()
+	s.signals = append(s.signals, args)
+	cmd, exist := s.cmds[args.CmdID]
+	s.mutex.Unlock()
+
+	if !exist {
+		writeError(w, "unknown cmdID")
+		return
+	}
+	select {
+	case cmd.signal <- args.Signal:
+	default:
+	}
+
+	writeJSON(w, xsapiv1.ExecSigResult{Status: "OK", CmdID: args.CmdID})
+}
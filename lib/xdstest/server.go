@@ -0,0 +1,422 @@
+/*
+ * Copyright (C) 2017 "IoT.bzh"
+ * Author Sebastien Douheret <sebastien@iot.bzh>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package xdstest provides an in-process fake XDS Server that can be used to
+// test XDS Agent or tools built on top of agent API without a real
+// xds-server.
+//
+// Typical usage:
+//
+//	svr := xdstest.NewServer()
+//	defer svr.Close()
+//	svr.SetCommand("make", xdstest.CommandScript{
+//		Output:   []xdstest.Output{{Stdout: "building...\n"}},
+//		ExitCode: 2,
+//	})
+//	// then use svr.URL as XDS Server url in agent config
+package xdstest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/googollee/go-socket.io"
+	"github.com/iotbzh/xds-server/lib/xsapiv1"
+	uuid "github.com/satori/go.uuid"
+)
+
+const apiPrefix = "/api/v1"
+
+// sidHeaderName Header used by XDS Server to identify client session
+const sidHeaderName = "Xds-Sid"
+
+// Server Fake XDS Server
+type Server struct {
+	*httptest.Server
+	Config xsapiv1.APIConfig // returned by GET /config (can be changed before connection)
+
+	mutex   sync.Mutex
+	sio     *socketio.Server
+	sockets map[string]socketio.Socket // indexed by session ID
+	folders map[string]xsapiv1.FolderConfig
+	sdks    []xsapiv1.SDK
+	scripts map[string]CommandScript
+	defScr  *CommandScript
+	cmds    map[string]*runningCmd
+	execs   []xsapiv1.ExecArgs
+	signals []xsapiv1.ExecSignalArgs
+	inputs  []string
+	events  []string
+}
+
+// NewServer Create and start a new fake XDS Server
+func NewServer() *Server {
+	s := &Server{
+		Config: xsapiv1.APIConfig{
+			ServerUID:     "fake-" + uuid.NewV1().String(),
+			Version:       "1.0.0",
+			APIVersion:    "1",
+			VersionGitTag: "fake",
+			SupportedSharing: map[string]bool{
+				string(xsapiv1.TypePathMap):   true,
+				string(xsapiv1.TypeCloudSync): false,
+			},
+		},
+		sockets: make(map[string]socketio.Socket),
+		folders: make(map[string]xsapiv1.FolderConfig),
+		sdks:    []xsapiv1.SDK{},
+		scripts: make(map[string]CommandScript),
+		cmds:    make(map[string]*runningCmd),
+	}
+
+	var err error
+	if s.sio, err = socketio.NewServer(nil); err != nil {
+		panic(fmt.Sprintf("xdstest: cannot create socket.io server: %v", err))
+	}
+	s.sio.On("connection", s.onSocketConnection)
+
+	mux := http.NewServeMux()
+	mux.Handle("/socket.io/", s.sio)
+	mux.HandleFunc(apiPrefix+"/", s.route)
+
+	s.Server = httptest.NewServer(s.withSession(mux))
+	return s
+}
+
+// AddSdk Declare a SDK returned by GET /sdks
+func (s *Server) AddSdk(sdk xsapiv1.SDK) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if sdk.Status == "" {
+		sdk.Status = xsapiv1.SdkStatusInstalled
+	}
+	s.sdks = append(s.sdks, sdk)
+}
+
+// AddFolder Declare an existing folder/project on server
+func (s *Server) AddFolder(fld xsapiv1.FolderConfig) xsapiv1.FolderConfig {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s._addFolder(fld)
+}
+
+// Folders Return folders declared on server
+func (s *Server) Folders() []xsapiv1.FolderConfig {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	res := []xsapiv1.FolderConfig{}
+	for _, f := range s.folders {
+		res = append(res, f)
+	}
+	return res
+}
+
+// Execs Return arguments of all received /exec requests
+func (s *Server) Execs() []xsapiv1.ExecArgs {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]xsapiv1.ExecArgs{}, s.execs...)
+}
+
+// Signals Return arguments of all received /signal requests
+func (s *Server) Signals() []xsapiv1.ExecSignalArgs {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]xsapiv1.ExecSignalArgs{}, s.signals...)
+}
+
+// Inputs Return data received through exec input events
+func (s *Server) Inputs() []string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]string{}, s.inputs...)
+}
+
+// RegisteredEvents Return names of events registered by clients
+func (s *Server) RegisteredEvents() []string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]string{}, s.events...)
+}
+
+// Emit Send an event to all connected clients
+func (s *Server) Emit(evName string, data interface{}) {
+	s.mutex.Lock()
+	socks := []socketio.Socket{}
+	for _, so := range s.sockets {
+		socks = append(socks, so)
+	}
+	s.mutex.Unlock()
+
+	for _, so := range socks {
+		so.Emit(evName, data)
+	}
+}
+
+/***
+** Private functions
+***/
+
+// withSession Set session ID header as done by xds-server
+func (s *Server) withSession(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sid := r.Header.Get(sidHeaderName)
+		if sid == "" {
+			sid = uuid.NewV1().String()
+			r.Header.Set(sidHeaderName, sid)
+		}
+		w.Header().Set(sidHeaderName, sid)
+		h.ServeHTTP(w, r)
+	})
+}
+
+func (s *Server) onSocketConnection(so socketio.Socket) {
+	sid := so.Request().Header.Get(sidHeaderName)
+	if sid == "" {
+		sid = so.Id()
+	}
+
+	s.mutex.Lock()
+	s.sockets[sid] = so
+	s.mutex.Unlock()
+
+	for _, evName := range []string{xsapiv1.ExecInEvent, xsapiv1.ExecInferiorInEvent} {
+		so.On(evName, func(stdin string) {
+			s.mutex.Lock()
+			s.inputs = append(s.inputs, stdin)
+			s.mutex.Unlock()
+		})
+	}
+
+	so.On("disconnection", func() {
+		s.mutex.Lock()
+		if s.sockets[sid] == so {
+			delete(s.sockets, sid)
+		}
+		s.mutex.Unlock()
+	})
+}
+
+// emitTo Send an event to client session (or to all clients when session
+// socket is unknown)
+func (s *Server) emitTo(sid string, evName string, data interface{}) {
+	s.mutex.Lock()
+	so, exist := s.sockets[sid]
+	s.mutex.Unlock()
+
+	if !exist {
+		s.Emit(evName, data)
+		return
+	}
+	so.Emit(evName, data)
+}
+
+// route Dispatch REST requests
+func (s *Server) route(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, apiPrefix), "/")
+	elems := strings.Split(strings.TrimPrefix(path, "/"), "/")
+
+	switch {
+	case path == "/version" && r.Method == "GET":
+		writeJSON(w, xsapiv1.Version{
+			ID:            s.Config.ServerUID,
+			Version:       s.Config.Version,
+			APIVersion:    s.Config.APIVersion,
+			VersionGitTag: s.Config.VersionGitTag,
+		})
+
+	case path == "/config" && r.Method == "GET":
+		writeJSON(w, s.Config)
+
+	case elems[0] == "folders":
+		s.routeFolders(w, r, elems[1:])
+
+	case path == "/sdks" && r.Method == "GET":
+		s.mutex.Lock()
+		sdks := append([]xsapiv1.SDK{}, s.sdks...)
+		s.mutex.Unlock()
+		writeJSON(w, sdks)
+
+	case elems[0] == "sdks" && len(elems) == 2 && r.Method == "GET":
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+		for _, sdk := range s.sdks {
+			if sdk.ID == elems[1] {
+				writeJSON(w, sdk)
+				return
+			}
+		}
+		writeError(w, "Invalid id")
+
+	case path == "/exec" && r.Method == "POST":
+		s.execCmd(w, r)
+
+	case path == "/signal" && r.Method == "POST":
+		s.signalCmd(w, r)
+
+	case path == "/events/register" && r.Method == "POST":
+		args := xsapiv1.EventRegisterArgs{}
+		if err := json.NewDecoder(r.Body).Decode(&args); err != nil {
+			writeError(w, "Invalid arguments")
+			return
+		}
+		s.mutex.Lock()
+		s.events = append(s.events, args.Name)
+		s.mutex.Unlock()
+		writeJSON(w, nil)
+
+	case path == "/events/unregister" && r.Method == "POST":
+		writeJSON(w, nil)
+
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// routeFolders Handle /folders requests
+func (s *Server) routeFolders(w http.ResponseWriter, r *http.Request, elems []string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	switch {
+	case len(elems) == 0 && r.Method == "GET":
+		res := []xsapiv1.FolderConfig{}
+		for _, f := range s.folders {
+			res = append(res, f)
+		}
+		writeJSON(w, res)
+
+	case len(elems) == 0 && r.Method == "POST":
+		fld := xsapiv1.FolderConfig{}
+		if err := json.NewDecoder(r.Body).Decode(&fld); err != nil {
+			writeError(w, "Invalid arguments")
+			return
+		}
+		if b := s.Config.SupportedSharing[string(fld.Type)]; !b {
+			writeError(w, "Unsupported folder type")
+			return
+		}
+		if fld.Type == xsapiv1.TypePathMap && fld.DataPathMap.CheckFile != "" {
+			if err := s.checkPathMap(fld); err != nil {
+				writeError(w, err.Error())
+				return
+			}
+		}
+		writeJSON(w, s._addFolder(fld))
+
+	case len(elems) == 2 && elems[0] == "sync" && r.Method == "POST":
+		fld, exist := s.folders[elems[1]]
+		if !exist {
+			writeError(w, "Invalid id")
+			return
+		}
+		go s.Emit(xsapiv1.EVTFolderStateChange, map[string]interface{}{
+			"time": time.Now().Format(time.RFC3339),
+			"type": xsapiv1.EVTFolderStateChange,
+			"data": fld,
+		})
+		writeJSON(w, nil)
+
+	case len(elems) == 1:
+		fld, exist := s.folders[elems[0]]
+		if !exist {
+			writeError(w, "Invalid id")
+			return
+		}
+		switch r.Method {
+		case "GET":
+			writeJSON(w, fld)
+		case "PUT":
+			upd := xsapiv1.FolderConfig{}
+			if err := json.NewDecoder(r.Body).Decode(&upd); err != nil {
+				writeError(w, "Invalid arguments")
+				return
+			}
+			fld.Label = upd.Label
+			fld.DefaultSdk = upd.DefaultSdk
+			fld.ClientData = upd.ClientData
+			s.folders[fld.ID] = fld
+			writeJSON(w, fld)
+		case "DELETE":
+			delete(s.folders, fld.ID)
+			writeJSON(w, fld)
+		default:
+			http.NotFound(w, r)
+		}
+
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// _addFolder Add a folder (must be called with mutex locked)
+func (s *Server) _addFolder(fld xsapiv1.FolderConfig) xsapiv1.FolderConfig {
+	if fld.ID == "" {
+		fld.ID = uuid.NewV1().String()
+	}
+	if fld.Type == xsapiv1.TypePathMap && fld.DataPathMap.ServerPath == "" {
+		fld.DataPathMap.ServerPath = fld.ClientPath
+	}
+	fld.Status = xsapiv1.StatusEnable
+	fld.IsInSync = true
+	fld.DataCloudSync.STSvrStatus = xsapiv1.StatusEnable
+	fld.DataCloudSync.STSvrIsInSync = true
+	s.folders[fld.ID] = fld
+	return fld
+}
+
+// checkPathMap Emulate path-mapping sanity check of xds-server: check file
+// written by client is read and completed (fake server shares client file
+// system)
+func (s *Server) checkPathMap(fld xsapiv1.FolderConfig) error {
+	file := fld.DataPathMap.CheckFile
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return fmt.Errorf("ServerPath sanity check error: %v", err)
+	}
+	if !strings.Contains(string(data), fld.DataPathMap.CheckContent) {
+		return fmt.Errorf("ServerPath sanity check error: file content differ")
+	}
+	fd, err := os.OpenFile(file, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		return fmt.Errorf("ServerPath sanity check error: %v", err)
+	}
+	defer fd.Close()
+	_, err = fd.WriteString("Pathmap checked message written by xds-server ID: " + s.Config.ServerUID + "\n")
+	return err
+}
+
+// writeJSON Send a JSON response
+func writeJSON(w http.ResponseWriter, data interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(data)
+}
+
+// writeError Send an error using the same format than xds-server
+func writeError(w http.ResponseWriter, msg string) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(map[string]string{"status": "error", "error": msg})
+}
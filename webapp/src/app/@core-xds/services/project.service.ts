@@ -47,6 +47,8 @@ export const ProjectStatus = {
   Enable: 'Enable',
   Pause: 'Pause',
   Syncing: 'Syncing',
+  Offline: 'Offline',
+  Pending: 'Pending',
 };
 
 export interface IUISettings {
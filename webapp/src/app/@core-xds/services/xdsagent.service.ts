@@ -63,6 +63,46 @@ export interface IXDSProjectConfig {
   isInSync?: boolean;
   defaultSdkID: string;
   clientData?: string;
+  confFiles?: string[];
+}
+
+export interface IXDSProjectBundle {
+  version: number;
+  label: string;
+  type: ProjectTypeEnum;
+  defaultSdk: string;
+  clientData: string;
+  clientPath: string;
+  serverPath: string;
+  serverName?: string;
+  env?: string[];
+  ignorePatterns?: string[];
+}
+
+export interface IXDSTemplateParam {
+  name: string;
+  description: string;
+  default: string;
+  required: boolean;
+}
+
+export interface IXDSProjectTemplate {
+  name: string;
+  description: string;
+  builtIn: boolean;
+  params: IXDSTemplateParam[];
+}
+
+export interface IXDSDiscoveredProject {
+  confFile: string;
+  clientPath: string;
+  projectId: string;
+  sdkId: string;
+  agentUrl: string;
+  action: string;
+  project?: IXDSProjectConfig;
+  applied: boolean;
+  error?: string;
 }
 
 export interface IXDSVer {
@@ -139,6 +179,7 @@ export class XDSAgentService {
   private baseUrl: string;
   private wsUrl: string;
   private httpSessionID: string;
+  private httpCsrfToken: string;
   private _config = <IXDSConfig>{ servers: [] };
   private _status = { connected: false, servers: [] };
 
@@ -161,12 +202,13 @@ export class XDSAgentService {
       .subscribe(
       resp => {
         this.httpSessionID = resp.headers.get('xds-agent-sid');
+        this.httpCsrfToken = resp.headers.get('x-csrf-token');
 
         const re = originUrl.match(/http[s]?:\/\/([^\/]*)[\/]?/);
         if (re === null || re.length < 2) {
           console.error('ERROR: cannot determine Websocket url');
         } else {
-          this.wsUrl = 'ws://' + re[1];
+          this.wsUrl = (originUrl.startsWith('https') ? 'wss://' : 'ws://') + re[1];
           this._handleIoSocket();
           this._RegisterEvents();
         }
@@ -419,6 +461,18 @@ export class XDSAgentService {
     return this._post('/projects', cfg);
   }
 
+  addProjectFromTemplate(cfg: IXDSProjectConfig, template: string, params: { [name: string]: string }): Observable<IXDSProjectConfig> {
+    return this._post('/projects', Object.assign({ template: template, params: params }, cfg));
+  }
+
+  discoverProjects(root: string, apply?: string[], serverID?: string): Observable<IXDSDiscoveredProject[]> {
+    return this._post('/projects/discover', { root: root, apply: apply, serverId: serverID });
+  }
+
+  getTemplates(): Observable<IXDSProjectTemplate[]> {
+    return this._get('/templates');
+  }
+
   deleteProject(id: string): Observable<IXDSProjectConfig> {
     return this._delete('/projects/' + id);
   }
@@ -431,6 +485,22 @@ export class XDSAgentService {
     return this._post('/projects/sync/' + id, {});
   }
 
+  migrateProject(id: string, serverID: string): Observable<IXDSProjectConfig> {
+    return this._post('/projects/' + id + '/migrate', { serverId: serverID });
+  }
+
+  setProjectFiles(id: string, files: string[], agentUrl?: string): Observable<IXDSProjectConfig> {
+    return this._post('/projects/' + id + '/files', { files: files, agentUrl: agentUrl });
+  }
+
+  exportProject(id: string): Observable<IXDSProjectBundle> {
+    return this._get('/projects/' + id + '/export');
+  }
+
+  importProject(bundle: IXDSProjectBundle, serverID?: string, pathMap?: { [from: string]: string }): Observable<IXDSProjectConfig> {
+    return this._post('/projects/import', { bundle: bundle, serverId: serverID, pathMap: pathMap });
+  }
+
   /***
   ** Exec
   ***/
@@ -482,11 +552,14 @@ export class XDSAgentService {
 
   private _attachAuthHeaders(options?: any) {
     options = options || {};
-    const headers = options.headers || new HttpHeaders();
+    let headers = options.headers || new HttpHeaders();
     // headers.append('Authorization', 'Basic ' + btoa('username:password'));
-    headers.append('Accept', 'application/json');
-    headers.append('Content-Type', 'application/json');
+    headers = headers.set('Accept', 'application/json');
+    headers = headers.set('Content-Type', 'application/json');
     // headers.append('Access-Control-Allow-Origin', '*');
+    if (this.httpCsrfToken) {
+      headers = headers.set('X-CSRF-Token', this.httpCsrfToken);
+    }
 
     options.headers = headers;
     return options;